@@ -0,0 +1,483 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError describes an invalid field. See Transaction.Bind
+type FieldError struct {
+	Field   string `json:"field"`   // name of the field, e.g. "email"
+	Rule    string `json:"rule"`    // rule which failed, e.g. "required", or "type"
+	Message string `json:"message"` // e.g. "is required"
+}
+
+func (e *FieldError) Error() string { return e.Field + " " + e.Message }
+
+// ValidationErrors is returned by Transaction.Bind when one or more fields are invalid.
+// RespondWithError responds to ValidationErrors with "400 Bad Request".
+type ValidationErrors []*FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Get returns the message of the error of field, or "" if the field is valid.
+// This is useful in templates, e.g.
+//
+//   <input name="email" value="{{.Form.Email}}">
+//   {{with .Errors.Get "email"}}<span class="error">{{.}}</span>{{end}}
+//
+func (e ValidationErrors) Get(field string) string {
+	for _, fe := range e {
+		if fe.Field == field {
+			return fe.Message
+		}
+	}
+	return ""
+}
+
+// Bind fills the struct pointed to by dst with request values and validates it.
+//
+// Fields are bound by name: the name of the "form" tag (e.g. `form:"email"`), or else the name
+// of the "json" tag, or else the name of the field. Fields tagged `form:"-"` are ignored and
+// the fields of embedded structs are bound as fields of the outer struct.
+//
+// Values are taken from, in increasing order of precedence: URL query-string parameters,
+// the request body and route vars (e.g. "id" in "/user/{id}"). Only the value of highest
+// precedence is used for each field, e.g. a form value replaces a query-string parameter of
+// the same name even if the parameter isn't valid. A JSON body is decoded as with ReadJSON,
+// while form bodies (url-encoded or multipart) are bound by name like query-string
+// parameters. Fields of types string, bool, integer, float, time.Time and
+// encoding.TextUnmarshaler, and pointers and slices of those, can be bound by name.
+//
+// Fields are validated according to their "validate" tag, a comma-separated list of rules:
+//
+//   required    must not be empty, nil or zero
+//   min=N       a number must be at least N; a string or slice must have at least N runes or items
+//   max=N       a number must be at most N; a string or slice must have at most N runes or items
+//   len=N       a string or slice must have exactly N runes or items
+//   email       a string must be an email address
+//   pattern=RE  a string must match the regular expression RE entirely. This must be the
+//               last rule since RE may contain commas.
+//
+// Rules other than required are not checked for empty strings, slices and nil pointers.
+//
+// If any values can't be converted to the type of their fields or any fields are invalid,
+// ValidationErrors is returned. Other errors, like a malformed JSON body, are *HTTPError.
+// Bind doesn't respond to the request. Example:
+//
+//   type signupForm struct {
+//     Name  string `form:"name" validate:"required,max=100"`
+//     Email string `form:"email" validate:"required,email"`
+//     Age   int    `form:"age" validate:"min=13"`
+//   }
+//   var form signupForm
+//   if err := t.Bind(&form); err != nil {
+//     var errs httpd.ValidationErrors
+//     if !errors.As(err, &errs) {
+//       return err
+//     }
+//     return t.WriteTemplate(signupTemplate, signupPage{Form: form, Errors: errs})
+//   }
+//
+func (t *Transaction) Bind(dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Bind: %T is not a pointer to a struct", dst)
+	}
+	v = v.Elem()
+	fields := bindFieldsOf(v.Type())
+
+	var body *jsonBody
+	var form url.Values
+	switch ctype := mediaType(t.Request.Header.Get("Content-Type")); {
+	case ctype == "application/json" || strings.HasSuffix(ctype, "+json"):
+		body = &jsonBody{dst: dst, strict: t.Server != nil && t.Server.StrictJSON}
+		if err := t.readJSON(body); err != nil {
+			return err
+		}
+	case ctype == "application/x-www-form-urlencoded" || ctype == "multipart/form-data":
+		form = t.Form()
+	}
+	var vars map[string]string
+	if t.routeMatch != nil {
+		vars = t.routeMatch.Vars()
+	}
+
+	var errs ValidationErrors
+	failed := map[string]bool{}
+	for _, f := range fields {
+		if !f.byName {
+			continue
+		}
+		var vals []string
+		if value, ok := vars[f.name]; ok {
+			vals = []string{value}
+		} else if vals = form[f.name]; len(vals) == 0 && !body.has(f) {
+			vals = t.Query()[f.name]
+		}
+		if len(vals) == 0 {
+			continue
+		}
+		if msg := setFieldValues(v.FieldByIndex(f.index), vals); msg != "" {
+			errs = append(errs, &FieldError{Field: f.name, Rule: "type", Message: msg})
+			failed[f.name] = true
+		}
+	}
+
+	for _, f := range fields {
+		if failed[f.name] {
+			continue
+		}
+		if rule, msg := f.validate(v.FieldByIndex(f.index)); msg != "" {
+			errs = append(errs, &FieldError{Field: f.name, Rule: rule, Message: msg})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// jsonBody decodes a JSON request body into dst and records the names of its members
+type jsonBody struct {
+	dst    interface{}
+	strict bool // disallow unknown fields
+	keys   map[string]json.RawMessage
+}
+
+func (b *jsonBody) UnmarshalJSON(data []byte) error {
+	json.Unmarshal(data, &b.keys) // a body which isn't an object fails to decode below
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if b.strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(b.dst)
+}
+
+// has returns true if the body has a member for the field f. b may be nil.
+func (b *jsonBody) has(f *bindField) bool {
+	if b == nil || f.jsonName == "" {
+		return false
+	}
+	for key := range b.keys {
+		if strings.EqualFold(key, f.jsonName) { // encoding/json matches names like this
+			return true
+		}
+	}
+	return false
+}
+
+// bindField describes a field of a struct bound by Transaction.Bind
+type bindField struct {
+	index    []int
+	name     string
+	jsonName string // name of the field in JSON objects, or "" if it's not decoded from JSON
+	byName   bool   // can be bound from query-string parameters, form values and route vars
+	rules    []bindRule
+}
+
+type bindRule struct {
+	name string // e.g. "min"
+	arg  string // e.g. "3" for "min=3"
+	n    float64
+	re   *regexp.Regexp
+}
+
+var (
+	bindFieldsCache sync.Map // map[reflect.Type][]*bindField
+
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func bindFieldsOf(typ reflect.Type) []*bindField {
+	if v, ok := bindFieldsCache.Load(typ); ok {
+		return v.([]*bindField)
+	}
+	fields := appendBindFields(nil, typ, nil)
+	bindFieldsCache.Store(typ, fields)
+	return fields
+}
+
+// appendBindFields adds the fields of the struct type typ to fields. It panics if a field has
+// invalid validation rules, since that's a programming error.
+func appendBindFields(fields []*bindField, typ reflect.Type, index []int) []*bindField {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		name := sf.Tag.Get("form")
+		if name == "-" {
+			continue
+		}
+		fieldIndex := append(index[:len(index):len(index)], i)
+		if sf.Anonymous && name == "" && sf.Type.Kind() == reflect.Struct {
+			fields = appendBindFields(fields, sf.Type, fieldIndex)
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		jsonName := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if jsonName == "" {
+			jsonName = sf.Name
+		} else if jsonName == "-" && sf.Tag.Get("json") == "-" {
+			jsonName = ""
+		}
+		if name == "" {
+			name = jsonName
+			if name == "" || name == "-" {
+				name = sf.Name
+			}
+		}
+		f := &bindField{
+			index:    fieldIndex,
+			name:     name,
+			jsonName: jsonName,
+			byName:   isBindableType(sf.Type),
+		}
+		var err error
+		if f.rules, err = parseBindRules(sf.Tag.Get("validate"), sf.Type); err != nil {
+			panic(fmt.Sprintf("httpd: invalid validate tag of %s.%s: %v", typ, sf.Name, err))
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func isBindableType(typ reflect.Type) bool {
+	if typ == timeType || reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		return typ.Elem().Kind() != reflect.Slice && isBindableType(typ.Elem())
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func parseBindRules(tag string, typ reflect.Type) ([]bindRule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	kind := typ.Kind()
+	isNumber := reflect.Int <= kind && kind <= reflect.Float64
+	isString := kind == reflect.String
+	var rules []bindRule
+	for tag != "" {
+		var term string
+		if strings.HasPrefix(tag, "pattern=") {
+			term, tag = tag, ""
+		} else if i := strings.IndexByte(tag, ','); i != -1 {
+			term, tag = tag[:i], tag[i+1:]
+		} else {
+			term, tag = tag, ""
+		}
+		r := bindRule{name: term}
+		if i := strings.IndexByte(term, '='); i != -1 {
+			r.name, r.arg = term[:i], term[i+1:]
+		}
+		var err error
+		switch r.name {
+		case "required":
+		case "min", "max", "len":
+			if !isNumber && !isString && kind != reflect.Slice {
+				return nil, fmt.Errorf("rule %q can't be used with %s", r.name, typ)
+			}
+			if r.name == "len" && isNumber {
+				return nil, fmt.Errorf("rule %q can't be used with %s", r.name, typ)
+			}
+			if r.n, err = strconv.ParseFloat(r.arg, 64); err != nil {
+				return nil, fmt.Errorf("invalid number in rule %q", term)
+			}
+		case "email", "pattern":
+			if !isString {
+				return nil, fmt.Errorf("rule %q can't be used with %s", r.name, typ)
+			}
+			if r.name == "pattern" {
+				if r.re, err = regexp.Compile(`^(?:` + r.arg + `)$`); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", r.name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// validate checks the value v of f against the rules of f and returns the rule which failed
+// along with a message, or "" if v is valid.
+func (f *bindField) validate(v reflect.Value) (rule, message string) {
+	for _, r := range f.rules {
+		if r.name == "required" {
+			if v.IsZero() {
+				return r.name, "is required"
+			}
+			continue
+		}
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return "", ""
+			}
+			v = v.Elem()
+		}
+		var n float64
+		unit := ""
+		switch v.Kind() {
+		case reflect.String:
+			s := v.String()
+			if s == "" {
+				return "", ""
+			}
+			switch r.name {
+			case "email":
+				if a, err := mail.ParseAddress(s); err != nil || a.Address != s {
+					return r.name, "must be an email address"
+				}
+				continue
+			case "pattern":
+				if !r.re.MatchString(s) {
+					return r.name, "is invalid"
+				}
+				continue
+			}
+			n, unit = float64(utf8.RuneCountInString(s)), " characters long"
+		case reflect.Slice:
+			if v.Len() == 0 {
+				return "", ""
+			}
+			n = float64(v.Len())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			n = v.Float()
+		}
+		switch {
+		case r.name == "min" && n < r.n:
+			if v.Kind() == reflect.Slice {
+				return r.name, "must have at least " + r.arg + " items"
+			}
+			return r.name, "must be at least " + r.arg + unit
+		case r.name == "max" && n > r.n:
+			if v.Kind() == reflect.Slice {
+				return r.name, "must have at most " + r.arg + " items"
+			}
+			return r.name, "must be at most " + r.arg + unit
+		case r.name == "len" && n != r.n:
+			if v.Kind() == reflect.Slice {
+				return r.name, "must have exactly " + r.arg + " items"
+			}
+			return r.name, "must be exactly " + r.arg + unit
+		}
+	}
+	return "", ""
+}
+
+// setFieldValues sets v to vals, converted to the type of v. The first value is used unless v
+// is a slice. A message describing the problem is returned if a value can't be converted.
+func setFieldValues(v reflect.Value, vals []string) string {
+	if v.Kind() == reflect.Slice && !reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		s := reflect.MakeSlice(v.Type(), len(vals), len(vals))
+		for i, val := range vals {
+			if msg := setFieldValue(s.Index(i), val); msg != "" {
+				return msg
+			}
+		}
+		v.Set(s)
+		return ""
+	}
+	return setFieldValue(v, vals[0])
+}
+
+func setFieldValue(v reflect.Value, s string) string {
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if msg := setFieldValue(p.Elem(), s); msg != "" {
+			return msg
+		}
+		v.Set(p)
+		return ""
+	}
+	if v.Type() == timeType {
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+			if tm, err := time.Parse(layout, s); err == nil {
+				v.Set(reflect.ValueOf(tm))
+				return ""
+			}
+		}
+		return "must be a date"
+	}
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := u.UnmarshalText([]byte(s)); err != nil {
+			return "is invalid"
+		}
+		return ""
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		if s == "on" { // checkbox
+			s = "true"
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return "must be true or false"
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return intErrorMessage(err)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return intErrorMessage(err)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return "must be a number"
+		}
+		v.SetFloat(n)
+	}
+	return ""
+}
+
+func intErrorMessage(err error) string {
+	if errors.Is(err, strconv.ErrRange) {
+		return "is out of range"
+	}
+	return "must be a whole number"
+}
@@ -0,0 +1,149 @@
+package httpd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+type bindBase struct {
+	ID int `form:"id"`
+}
+
+type bindForm struct {
+	bindBase
+	Name   string   `form:"name" validate:"required,max=5"`
+	Email  string   `json:"email" validate:"email"`
+	Age    *int     `form:"age" validate:"min=13,max=120"`
+	Tags   []string `form:"tag" validate:"max=2"`
+	Code   string   `form:"code" validate:"len=3,pattern=[a-z]{1,2}[0-9]"`
+	Agree  bool     `form:"agree"`
+	Skip   string   `form:"-"`
+	secret string
+}
+
+func TestTransactionBind(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var form bindForm
+	var err error
+	s.HandleFunc("/u/{id}", func(t *Transaction) {
+		form = bindForm{}
+		err = t.Bind(&form)
+	})
+	const formType = "application/x-www-form-urlencoded"
+	const jsonType = "application/json"
+
+	serve(s, "POST", "/u/7?name=query&Skip=x", strings.NewReader(
+		"name=bob&email=a@b.co&age=20&tag=a&tag=b&code=ab1&agree=on&id=3"),
+		"Content-Type", formType)
+	assert.NoErr("valid form", err)
+	assert.Eq("route var wins over form value", form.ID, 7)
+	assert.Eq("form value wins over query", form.Name, "bob")
+	assert.Eq("json tag name", form.Email, "a@b.co")
+	assert.Ok("pointer", form.Age != nil && *form.Age == 20)
+	assert.Eq("slice", strings.Join(form.Tags, " "), "a b")
+	assert.Eq("pattern", form.Code, "ab1")
+	assert.Ok("bool", form.Agree)
+	assert.Eq("ignored field", form.Skip, "")
+
+	serve(s, "POST", "/u/7", strings.NewReader(
+		"name=toolong&email=nope&age=x&tag=a&tag=b&tag=c&code=abc"),
+		"Content-Type", formType)
+	var errs ValidationErrors
+	if assert.Ok("ValidationErrors", errors.As(err, &errs)) {
+		assert.Eq("number of errors", len(errs), 5)
+		assert.Eq("max", errs.Get("name"), "must be at most 5 characters long")
+		assert.Eq("email", errs.Get("email"), "must be an email address")
+		assert.Eq("type", errs.Get("age"), "must be a whole number")
+		assert.Eq("max items", errs.Get("tag"), "must have at most 2 items")
+		assert.Eq("pattern", errs.Get("code"), "is invalid")
+	}
+
+	serve(s, "POST", "/u/7", strings.NewReader(`{"Name":"j","email":"x@y.z","Age":5}`),
+		"Content-Type", jsonType)
+	if assert.Ok("JSON ValidationErrors", errors.As(err, &errs)) {
+		assert.Eq("JSON number of errors", len(errs), 1)
+		assert.Eq("JSON min", errs.Get("age"), "must be at least 13")
+	}
+	assert.Eq("JSON value", form.Name, "j")
+	assert.Eq("route var with JSON body", form.ID, 7)
+
+	serve(s, "GET", "/u/x", nil)
+	if assert.Ok("route var ValidationErrors", errors.As(err, &errs)) {
+		assert.Eq("route var number of errors", len(errs), 2)
+		assert.Eq("route var type", errs.Get("id"), "must be a whole number")
+		assert.Eq("required", errs.Get("name"), "is required")
+	}
+
+	serve(s, "POST", "/u/1", strings.NewReader(`{`), "Content-Type", jsonType)
+	var herr *HTTPError
+	assert.Ok("malformed JSON is a HTTPError", errors.As(err, &herr) && herr.Status == 400)
+}
+
+func TestTransactionBindPrecedence(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var form bindForm
+	var err error
+	s.HandleFunc("/u/{id}", func(t *Transaction) {
+		form = bindForm{}
+		err = t.Bind(&form)
+	})
+
+	// invalid values are ignored when a source of higher precedence has a value
+	serve(s, "POST", "/u/7?name=bob&age=x&id=x", strings.NewReader("age=20"),
+		"Content-Type", "application/x-www-form-urlencoded")
+	assert.NoErr("invalid query values replaced by form and route var", err)
+	assert.Ok("age from form", form.Age != nil && *form.Age == 20)
+	assert.Eq("id from route var", form.ID, 7)
+
+	serve(s, "POST", "/u/7?age=x&name=bob", strings.NewReader(`{"AGE":20}`),
+		"Content-Type", "application/json")
+	assert.NoErr("invalid query value replaced by JSON member", err)
+	assert.Ok("age from JSON", form.Age != nil && *form.Age == 20)
+	assert.Eq("name from query", form.Name, "bob")
+
+	serve(s, "POST", "/u/7?age=x", strings.NewReader(`{"name":"bob"}`),
+		"Content-Type", "application/json")
+	var errs ValidationErrors
+	if assert.Ok("invalid query value without JSON member", errors.As(err, &errs)) {
+		assert.Eq("type", errs.Get("age"), "must be a whole number")
+	}
+}
+
+func TestTransactionBindResponse(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.Handle("/", ErrorHandlerFunc(func(t *Transaction) error {
+		var form bindForm
+		if err := t.Bind(&form); err != nil {
+			return err
+		}
+		return t.WriteJSON(200, form.Name)
+	}))
+	w := serve(s, "GET", "/?name=bob&age=5", nil)
+	assert.Eq("status", w.Code, 400)
+	assert.Ok("body: "+w.Body.String(), strings.Contains(w.Body.String(), "age must be at least 13"))
+	w = serve(s, "GET", "/?name=bob", nil)
+	assert.Eq("valid status", w.Code, 200)
+	assert.Eq("valid body", strings.TrimSpace(w.Body.String()), `"bob"`)
+}
+
+func TestTransactionBindInvalidRules(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var v struct {
+		N int `validate:"email"`
+	}
+	s.HandleFunc("/", func(t *Transaction) {
+		defer func() {
+			msg, _ := recover().(string)
+			assert.Ok("panic: "+msg, strings.Contains(msg, "invalid validate tag"))
+		}()
+		t.Bind(&v)
+	})
+	serve(s, "GET", "/", nil)
+}
@@ -0,0 +1,286 @@
+package httpd
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Compression configures compression of responses. See Server.Compression
+type Compression struct {
+	// MinSize is the size of the smallest response which is compressed
+	MinSize int
+
+	// ContentTypes are the media types of responses which are compressed.
+	// An entry ending in "/*" matches any subtype, e.g. "text/*", and an entry starting with "+"
+	// matches a structured syntax suffix, e.g. "+json" matches "application/ld+json".
+	ContentTypes []string
+
+	// Level is the compression level, e.g. flate.BestSpeed. Zero means flate.DefaultCompression.
+	Level int
+}
+
+// DefaultCompression is the compression of servers created with NewServer
+var DefaultCompression = Compression{
+	MinSize: 1024,
+	ContentTypes: []string{
+		"text/*",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"image/svg+xml",
+		"+json",
+		"+xml",
+	},
+}
+
+// DisableCompression disables compression of the response. It has no effect after the
+// response header has been written.
+func (t *Transaction) DisableCompression() {
+	t.noCompress = true
+}
+
+// NoCompression is middleware which disables compression of responses, e.g. of a route which
+// serves data that is already compressed:
+//
+//   r, _ := s.Routes.HandleFunc("/video/{id}", serveVideo)
+//   r.Use(httpd.NoCompression)
+//
+func NoCompression(next Handler) Handler {
+	return handlerFunc(func(t *Transaction) {
+		t.DisableCompression()
+		next.ServeHTTP(t)
+	})
+}
+
+// compressible returns true if responses of contentType should be compressed
+func (c *Compression) compressible(contentType string) bool {
+	mt := mediaType(contentType)
+	if mt == "" {
+		return false
+	}
+	for _, s := range c.ContentTypes {
+		switch {
+		case s[0] == '+':
+			if strings.HasSuffix(mt, s) {
+				return true
+			}
+		case strings.HasSuffix(s, "/*"):
+			if strings.HasPrefix(mt, s[:len(s)-1]) {
+				return true
+			}
+		case mt == s:
+			return true
+		}
+	}
+	return false
+}
+
+// compressor is implemented by gzip.Writer and zlib.Writer
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter compresses the response of a transaction. Until MinSize bytes have been
+// written, the data is buffered and the response header is held back, so that small responses
+// can be sent uncompressed.
+type compressWriter struct {
+	encoding string // "gzip" or "deflate" (the zlib format; RFC 9110 section 8.4.1.2)
+	level    int
+	status   int
+	minSize  int
+	head     bool       // response to a HEAD request; the body is discarded
+	buf      []byte     // data buffered while w is nil
+	w        compressor // nil until compression has started
+}
+
+// headCompressor discards the body of a response to a HEAD request
+type headCompressor struct{}
+
+func (headCompressor) Write(p []byte) (int, error) { return len(p), nil }
+func (headCompressor) Close() error                { return nil }
+func (headCompressor) Flush() error                { return nil }
+func (headCompressor) Reset(w io.Writer)           {}
+
+// compressors holds free gzip and zlib writers, indexed by level
+var (
+	gzipWriters [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+	zlibWriters [flate.BestCompression - flate.HuffmanOnly + 1]sync.Pool
+)
+
+// startCompression is called when the response header is about to be written and returns
+// true if the response is to be compressed, in which case writing the header is left to
+// t.compress.
+//
+// The response to a HEAD request gets the same header as the response to a GET request would.
+func (t *Transaction) startCompression(status int) bool {
+	if t.Server == nil || t.Server.Compression == nil || t.noCompress ||
+		status < 200 || status == 204 || status == 206 ||
+		status == 304 || t.Request.Header.Get("Range") != "" {
+		return false
+	}
+	c := t.Server.Compression
+	h := t.Header()
+	if h.Get("Content-Encoding") != "" || !c.compressible(h.Get("Content-Type")) {
+		return false
+	}
+	t.addVary("Accept-Encoding")
+	encoding := acceptedEncoding(t.Request.Header.Values("Accept-Encoding"))
+	if encoding == "" {
+		return false
+	}
+	size := int64(-1)
+	if s := h.Get("Content-Length"); s != "" {
+		var err error
+		if size, err = strconv.ParseInt(s, 10, 64); err != nil || size < int64(c.MinSize) {
+			return false
+		}
+	}
+	level := c.Level
+	if level == 0 || level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+	t.compress = &compressWriter{
+		encoding: encoding,
+		level:    level,
+		status:   status,
+		minSize:  c.MinSize,
+		head:     t.Request.Method == "HEAD",
+	}
+	if size != -1 {
+		t.compress.begin(t)
+	}
+	return true
+}
+
+// begin writes the response header and starts compressing
+func (c *compressWriter) begin(t *Transaction) {
+	h := t.Header()
+	h.Set("Content-Encoding", c.encoding)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	t.ResponseWriter.WriteHeader(c.status)
+	if c.head {
+		c.w = headCompressor{}
+		c.buf = nil
+		return
+	}
+	i := c.level - flate.HuffmanOnly
+	if c.encoding == "gzip" {
+		if w, ok := gzipWriters[i].Get().(compressor); ok {
+			w.Reset(t.ResponseWriter)
+			c.w = w
+		} else {
+			c.w, _ = gzip.NewWriterLevel(t.ResponseWriter, c.level)
+		}
+	} else {
+		if w, ok := zlibWriters[i].Get().(compressor); ok {
+			w.Reset(t.ResponseWriter)
+			c.w = w
+		} else {
+			c.w, _ = zlib.NewWriterLevel(t.ResponseWriter, c.level)
+		}
+	}
+	if len(c.buf) > 0 {
+		c.w.Write(c.buf)
+		c.buf = nil
+	}
+}
+
+func (c *compressWriter) write(t *Transaction, p []byte) (int, error) {
+	if c.w == nil {
+		if len(c.buf)+len(p) < c.minSize {
+			c.buf = append(c.buf, p...)
+			return len(p), nil
+		}
+		c.begin(t)
+	}
+	return c.w.Write(p)
+}
+
+func (c *compressWriter) flush(t *Transaction) {
+	if c.w == nil {
+		c.begin(t)
+	}
+	c.w.Flush()
+}
+
+// endCompression completes a compressed response after the handler has returned.
+// A response smaller than MinSize is sent uncompressed.
+func (t *Transaction) endCompression() {
+	c := t.compress
+	if c == nil {
+		return
+	}
+	t.compress = nil
+	if c.w == nil {
+		if c.head {
+			if len(c.buf) > 0 {
+				t.Header().Set("Content-Length", strconv.Itoa(len(c.buf)))
+			}
+			t.ResponseWriter.WriteHeader(c.status)
+			return
+		}
+		t.Header().Set("Content-Length", strconv.Itoa(len(c.buf)))
+		t.ResponseWriter.WriteHeader(c.status)
+		t.ResponseWriter.Write(c.buf)
+		return
+	}
+	if err := c.w.Close(); err == nil && !c.head {
+		c.w.Reset(nil)
+		i := c.level - flate.HuffmanOnly
+		if c.encoding == "gzip" {
+			gzipWriters[i].Put(c.w)
+		} else {
+			zlibWriters[i].Put(c.w)
+		}
+	}
+}
+
+// acceptedEncoding returns the compression encoding most preferred by the Accept-Encoding
+// header values accept, "gzip" or "deflate", or "" if neither is acceptable
+func acceptedEncoding(accept []string) string {
+	gzipQ, deflateQ, anyQ := -1.0, -1.0, -1.0
+	for _, value := range accept {
+		for _, s := range strings.Split(value, ",") {
+			params := strings.Split(s, ";")
+			coding := strings.ToLower(strings.TrimSpace(params[0]))
+			q := 1.0
+			for _, p := range params[1:] {
+				p = strings.TrimSpace(p)
+				if len(p) > 2 && (p[0] == 'q' || p[0] == 'Q') && p[1] == '=' {
+					if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+						q = v
+					}
+				}
+			}
+			switch coding {
+			case "gzip", "x-gzip":
+				gzipQ = q
+			case "deflate":
+				deflateQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
@@ -0,0 +1,243 @@
+package httpd
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	for _, test := range []struct {
+		accept   string
+		encoding string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"x-gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"deflate, gzip;q=0.5", "deflate"},
+		{"GZIP;Q=0.5, deflate;q=0.4", "gzip"},
+		{"gzip;q=0, deflate", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"br, *;q=0", ""},
+	} {
+		assert.Eq(test.accept, acceptedEncoding([]string{test.accept}), test.encoding)
+	}
+	assert.Eq("multiple values", acceptedEncoding([]string{"br", "deflate"}), "deflate")
+}
+
+func TestCompressible(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	c := &DefaultCompression
+	for _, ctype := range []string{
+		"text/html; charset=utf-8", "TEXT/CSS", "application/json", "application/ld+json",
+		"image/svg+xml", "application/atom+xml",
+	} {
+		assert.Ok(ctype, c.compressible(ctype))
+	}
+	for _, ctype := range []string{"", "image/png", "application/octet-stream", "textual/x"} {
+		assert.Ok(ctype+" not compressible", !c.compressible(ctype))
+	}
+}
+
+func gunzip(t *testing.T, r io.Reader) string {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return string(b)
+}
+
+// inflate decodes a "deflate" response body, which is in the zlib format
+func inflate(t *testing.T, r io.Reader) string {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		t.Fatalf("zlib: %v", err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("zlib: %v", err)
+	}
+	return string(b)
+}
+
+// compressServer returns a server with routes and files for testing compression.
+// big is larger than DefaultCompression.MinSize.
+func compressServer(t *testing.T) (s *Server, big string) {
+	dir := t.TempDir()
+	big = strings.Repeat("hello world ", 200)
+	ioutil.WriteFile(filepath.Join(dir, "big.txt"), []byte(big), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "img.png"), []byte(big), 0644)
+	s = NewServer(dir, "")
+	s.HandleFunc("/small", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteString("tiny")
+	})
+	s.HandleFunc("/big", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/html; charset=utf-8")
+		for i := 0; i < 200; i++ {
+			t.WriteString("hello world ")
+		}
+	})
+	s.HandleFunc("/json", func(t *Transaction) {
+		t.Respond(map[string]string{"a": big})
+	})
+	s.HandleFunc("/off", func(t *Transaction) {
+		t.DisableCompression()
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteString(big)
+	})
+	r, _ := s.Routes.HandleFunc("/mw", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteString(big)
+	})
+	r.Use(NoCompression)
+	s.HandleFunc("/flush", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteString("a")
+		t.Flush()
+		t.WriteString("b")
+	})
+	return s, big
+}
+
+func TestServerCompression(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s, big := compressServer(t)
+
+	// responses smaller than MinSize are buffered and sent uncompressed
+	w := serve(s, "GET", "/small", nil, "Accept-Encoding", "gzip")
+	assert.Eq("small Content-Encoding", w.Header().Get("Content-Encoding"), "")
+	assert.Eq("small Content-Length", w.Header().Get("Content-Length"), "4")
+	assert.Eq("small Vary", w.Header().Get("Vary"), "Accept-Encoding")
+	assert.Eq("small body", w.Body.String(), "tiny")
+
+	w = serve(s, "GET", "/big", nil, "Accept-Encoding", "gzip")
+	assert.Eq("gzip Content-Encoding", w.Header().Get("Content-Encoding"), "gzip")
+	assert.Eq("gzip Content-Length", w.Header().Get("Content-Length"), "")
+	assert.Eq("gzip body", gunzip(t, w.Body), big)
+
+	w = serve(s, "GET", "/big", nil, "Accept-Encoding", "deflate, gzip;q=0.5")
+	assert.Eq("deflate Content-Encoding", w.Header().Get("Content-Encoding"), "deflate")
+	assert.Eq("deflate body", inflate(t, w.Body), big)
+
+	// pooled writers are reused for each level
+	s.Compression.Level = flate.BestSpeed
+	for i := 0; i < 2; i++ {
+		w = serve(s, "GET", "/big", nil, "Accept-Encoding", "deflate")
+		assert.Eq("deflate BestSpeed body", inflate(t, w.Body), big)
+		w = serve(s, "GET", "/big", nil, "Accept-Encoding", "gzip")
+		assert.Eq("gzip BestSpeed body", gunzip(t, w.Body), big)
+	}
+	s.Compression.Level = 0
+
+	w = serve(s, "GET", "/big", nil, "Accept-Encoding", "gzip;q=0, identity")
+	assert.Eq("not accepted Content-Encoding", w.Header().Get("Content-Encoding"), "")
+	assert.Eq("not accepted Vary", w.Header().Get("Vary"), "Accept-Encoding")
+	assert.Eq("not accepted body", w.Body.String(), big)
+
+	w = serve(s, "GET", "/json", nil, "Accept-Encoding", "gzip")
+	assert.Eq("json Content-Encoding", w.Header().Get("Content-Encoding"), "gzip")
+	assert.Eq("json Vary", strings.Join(w.Header()["Vary"], ", "), "Accept, Accept-Encoding")
+	assert.Ok("json body", strings.Contains(gunzip(t, w.Body), `"a":"hello world`))
+
+	// files with a known size are compressed right away
+	w = serve(s, "GET", "/big.txt", nil, "Accept-Encoding", "gzip")
+	assert.Eq("file Content-Encoding", w.Header().Get("Content-Encoding"), "gzip")
+	assert.Eq("file Content-Length", w.Header().Get("Content-Length"), "")
+	assert.Eq("file Accept-Ranges", w.Header().Get("Accept-Ranges"), "")
+	assert.Eq("file body", gunzip(t, w.Body), big)
+
+	w = serve(s, "GET", "/big.txt", nil, "Accept-Encoding", "gzip", "Range", "bytes=0-3")
+	assert.Eq("range status", w.Code, 206)
+	assert.Eq("range Content-Encoding", w.Header().Get("Content-Encoding"), "")
+	assert.Eq("range body", w.Body.String(), "hell")
+
+	for _, path := range []string{"/off", "/mw", "/img.png"} {
+		w = serve(s, "GET", path, nil, "Accept-Encoding", "gzip")
+		assert.Eq(path+" Content-Encoding", w.Header().Get("Content-Encoding"), "")
+		assert.Eq(path+" body", w.Body.String(), big)
+	}
+
+	// flushing starts compression of a response smaller than MinSize
+	w = serve(s, "GET", "/flush", nil, "Accept-Encoding", "gzip")
+	assert.Ok("flushed", w.Flushed)
+	assert.Eq("flush Content-Encoding", w.Header().Get("Content-Encoding"), "gzip")
+	assert.Eq("flush body", gunzip(t, w.Body), "ab")
+
+	s.Compression = nil
+	w = serve(s, "GET", "/big", nil, "Accept-Encoding", "gzip")
+	assert.Eq("disabled Content-Encoding", w.Header().Get("Content-Encoding"), "")
+	assert.Eq("disabled Vary", w.Header().Get("Vary"), "")
+}
+
+func TestServerCompressionHEAD(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s, _ := compressServer(t)
+	s.HandleFunc("HEAD /explicit", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.Header().Set("Content-Length", "2000")
+		t.WriteHeader(200)
+	})
+	s.HandleFunc("GET /get", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteString(strings.Repeat("x", 2000))
+	})
+	s.HandleFunc("GET /get-small", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteString("x")
+	})
+	header := func(w *httptest.ResponseRecorder) string {
+		h := w.Header()
+		return strings.Join([]string{
+			h.Get("Content-Encoding"), h.Get("Content-Length"), h.Get("Vary"),
+		}, "|")
+	}
+	for _, test := range []struct {
+		path, accept, header string
+	}{
+		{"/big", "gzip", "gzip||Accept-Encoding"},
+		{"/big", "deflate", "deflate||Accept-Encoding"},
+		{"/big", "", "||Accept-Encoding"},
+		{"/small", "gzip", "|4|Accept-Encoding"},
+		{"/get", "gzip", "gzip||Accept-Encoding"},
+		{"/get", "", "|2000|Accept-Encoding"},
+		{"/get-small", "gzip", "|1|Accept-Encoding"},
+		{"/big.txt", "gzip", "gzip||Accept-Encoding"},
+		{"/img.png", "gzip", "|2400|"},
+		{"/off", "gzip", "||"},
+		{"/mw", "gzip", "||"},
+	} {
+		w := serve(s, "HEAD", test.path, nil, "Accept-Encoding", test.accept)
+		name := "HEAD " + test.path + " " + test.accept
+		assert.Eq(name, header(w), test.header)
+		if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Length") != "" {
+			assert.Eq(name+" body", w.Body.Len(), 0)
+		}
+		// an uncompressed GET response of unknown size is streamed without Content-Length
+		if test.header != "|2000|Accept-Encoding" {
+			w = serve(s, "GET", test.path, nil, "Accept-Encoding", test.accept)
+			assert.Eq(name+" same as GET", header(w), test.header)
+		}
+	}
+	w := serve(s, "HEAD", "/explicit", nil, "Accept-Encoding", "gzip")
+	assert.Eq("explicit HEAD route", header(w), "gzip||Accept-Encoding")
+	assert.Eq("explicit HEAD route body", w.Body.Len(), 0)
+}
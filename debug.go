@@ -0,0 +1,98 @@
+package httpd
+
+import (
+	"encoding/json"
+	html_template "html/template"
+	"strings"
+
+	"github.com/rsms/go-httpd/route"
+)
+
+// DebugRoutes is a handler function which lists the routes of t.Server.CurrentRoutes(), for
+// debugging. The list is rendered as JSON when the request accepts "application/json" or has
+// the query parameter "format=json", and as HTML otherwise. When the query parameter "path" is
+// set, the handler also explains how a request for that path is matched, with the method and
+// host of the query parameters "method" (defaults to GET) and "host".
+//
+// The handler only responds when DevMode is true; otherwise it responds with
+// "404 Not Found". Example:
+//
+//   s.HandleFunc("GET /_routes", httpd.DebugRoutes)
+//
+func DebugRoutes(t *Transaction) {
+	if !DevMode {
+		t.RespondWithStatusNotFound()
+		return
+	}
+	r := t.Server.CurrentRoutes()
+	q := t.Query()
+	data := debugRoutesData{Routes: r.RouteTable(), Path: q.Get("path")}
+	if data.Path != "" {
+		data.Method = strings.ToUpper(q.Get("method"))
+		if data.Method == "" {
+			data.Method = "GET"
+		}
+		data.Host = q.Get("host")
+		data.Explain = r.Explain(data.Method, data.Host, data.Path)
+	}
+	if q.Get("format") == "json" ||
+		strings.Contains(t.Request.Header.Get("Accept"), "application/json") {
+		t.Header().Set("Content-Type", "application/json; charset=utf-8")
+		enc := json.NewEncoder(t)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(&data); err != nil {
+			t.Server.LogError("DebugRoutes: %v", err)
+		}
+		return
+	}
+	t.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugRoutesTemplate.Execute(t, &data); err != nil {
+		t.Server.LogError("DebugRoutes: %v", err)
+	}
+}
+
+type debugRoutesData struct {
+	Routes  []route.RouteInfo   `json:"routes"`
+	Method  string              `json:"method,omitempty"`
+	Host    string              `json:"host,omitempty"`
+	Path    string              `json:"path,omitempty"`
+	Explain []route.Explanation `json:"explain,omitempty"`
+}
+
+var debugRoutesTemplate = html_template.Must(html_template.New("routes").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Routes</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: 3px 10px 3px 0; vertical-align: top; }
+code { font-size: 13px; }
+.matched { font-weight: bold; }
+.mount { padding-left: 2em; }
+</style></head><body>
+<form>
+  <input name="method" value="{{or .Method "GET"}}" size="8">
+  <input name="host" value="{{.Host}}" placeholder="host">
+  <input name="path" value="{{.Path}}" placeholder="/path" size="40">
+  <button>Explain</button>
+</form>
+{{define "explain"}}<ol>{{range .}}
+  <li{{if .Matched}} class="matched"{{end}}><code>{{.Pattern}}</code>
+  {{if .Matched}}matched{{else}}&mdash; {{.Reason}}{{end}}
+  {{if .Mount}}{{template "explain" .Mount}}{{end}}</li>
+{{end}}</ol>{{end}}
+{{if .Path}}<h2>{{.Method}} {{.Host}}{{.Path}}</h2>
+{{if .Explain}}{{template "explain" .Explain}}{{else}}<p>No routes</p>{{end}}{{end}}
+{{define "table"}}<table>
+<tr><th>Pattern</th><th>Conditions</th><th>Vars</th><th>Name</th><th>Handler</th></tr>
+{{range .}}<tr>
+  <td><code>{{.Pattern}}</code></td>
+  <td>{{range .Conditions}}<code>{{.}}</code> {{end}}</td>
+  <td>{{range .Vars}}<code>{{.}}</code> {{end}}</td>
+  <td>{{.Name}}</td>
+  <td><code>{{.Handler}}</code></td>
+</tr>{{if .Mount}}<tr><td colspan="5" class="mount">{{template "table" .Mount}}</td></tr>{{end}}
+{{end}}</table>{{end}}
+<h2>Routes</h2>
+{{template "table" .Routes}}
+</body></html>
+`))
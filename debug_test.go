@@ -0,0 +1,59 @@
+package httpd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestDebugRoutes(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.HandleFunc("GET /_routes", DebugRoutes)
+	s.HandleFunc("GET /a/{id:int}", func(t *Transaction) {})
+	s.HandleFunc("HEAD /a/{id:int}", func(t *Transaction) {})
+
+	defer func(devMode bool) { DevMode = devMode }(DevMode)
+	DevMode = false
+	assert.Eq("not in DevMode", serve(s, "GET", "/_routes", nil).Code, 404)
+	DevMode = true
+
+	w := serve(s, "GET", "/_routes?format=json&method=head&path=/a/1", nil)
+	assert.Eq("JSON Content-Type", w.Header().Get("Content-Type"), "application/json; charset=utf-8")
+	var data struct {
+		Routes []struct {
+			Pattern string
+			Vars    []string
+			Handler string
+		}
+		Method  string
+		Explain []struct {
+			Pattern string
+			Matched bool
+			Reason  string
+		}
+	}
+	assert.NoErr("JSON", json.Unmarshal(w.Body.Bytes(), &data))
+	assert.Eq("routes", len(data.Routes), 3)
+	assert.Eq("route pattern", data.Routes[1].Pattern, "GET /a/{id:int}")
+	assert.Eq("route vars", strings.Join(data.Routes[1].Vars, " "), "id")
+	assert.Eq("route handler", data.Routes[0].Handler, "github.com/rsms/go-httpd.DebugRoutes")
+	assert.Eq("method", data.Method, "HEAD")
+	if assert.Eq("explanations", len(data.Explain), 3) {
+		assert.Eq("explicit HEAD route preferred", data.Explain[1].Reason,
+			"a route which lists HEAD explicitly is preferred")
+		assert.Ok("HEAD route matched", data.Explain[2].Matched)
+	}
+
+	w = serve(s, "GET", "/_routes?path=/a/x", nil, "Accept", "application/json")
+	assert.Ok("JSON via Accept", json.Valid(w.Body.Bytes()))
+
+	w = serve(s, "GET", "/_routes?path=/b/<x>", nil)
+	assert.Eq("HTML Content-Type", w.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	body := w.Body.String()
+	assert.Ok("HTML lists routes", strings.Contains(body, "<code>HEAD /a/{id:int}</code>"))
+	assert.Ok("HTML explains", strings.Contains(body, "<h2>GET /b/&lt;x&gt;</h2>"))
+	assert.Ok("HTML reasons", strings.Contains(body, "path does not match"))
+}
@@ -0,0 +1,142 @@
+package httpd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrorRenderer writes a response for a status code, typically an error, along with an
+// optional public message. It's used by RespondWithMessage, and so by RespondWithStatus and
+// its RespondWithStatusX variants, by RespondWithError, by panic recovery and when no route or
+// file matches a request. See Server.ErrorRenderer and DefaultErrorRenderer
+type ErrorRenderer func(t *Transaction, status int, message string)
+
+// ErrorPageData is the data of error page templates. See DefaultErrorRenderer
+type ErrorPageData struct {
+	Status     int    `json:"status"`            // e.g. 404
+	StatusText string `json:"error"`             // e.g. "Not Found"
+	Message    string `json:"message,omitempty"` // e.g. "no such user" (may be empty)
+}
+
+// DefaultErrorRenderer is the ErrorRenderer used when Server.ErrorRenderer is nil.
+//
+// It responds with JSON when the request prefers "application/json" over "text/html",
+// for example:
+//
+//   {"status":404,"error":"Not Found","message":"no such user"}
+//
+// Otherwise it responds with HTML. For error statuses (400 and above) the HTML template
+// "<status>.html" (e.g. "404.html") or else "error.html" is used if it exists in
+// t.Server.PubDir. Templates are executed with an ErrorPageData value and are cached unless
+// DevMode is true. When there's no template, a simple page is rendered.
+func DefaultErrorRenderer(t *Transaction, status int, message string) {
+	t.Status = status
+	data := ErrorPageData{Status: status, StatusText: http.StatusText(status), Message: message}
+	var body []byte
+	if t.Negotiate("text/html", "application/json") == "application/json" {
+		body, _ = json.Marshal(&data)
+		body = append(body, '\n')
+		t.Header().Set("Content-Type", "application/json; charset=utf-8")
+	} else {
+		if status >= 400 && t.Server != nil && t.Server.PubDir != "" {
+			if tpl := t.Server.errorTemplate(status); tpl != nil {
+				var err error
+				if body, err = tpl.ExecBuf(&data); err != nil {
+					t.Server.LogError("error page template %s: %v", tpl.Name(), err)
+					body = nil
+				}
+			}
+		}
+		if body == nil {
+			s := "<body><h1>" + html.EscapeString(data.StatusText) + "</h1>"
+			if message != "" {
+				s += "<code>" + html.EscapeString(message) + "</code>"
+			}
+			body = []byte(s + "</body>")
+		}
+		t.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	t.addVary("Accept")
+	t.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	t.Write(body)
+}
+
+// errorTemplate returns the template for status in s.PubDir, or nil if there is none.
+// Templates are cached in s.errorTemplates by filename; nil for missing files.
+func (s *Server) errorTemplate(status int) Template {
+	for _, name := range []string{strconv.Itoa(status) + ".html", "error.html"} {
+		filename := filepath.Join(s.PubDir, name)
+		if !DevMode {
+			if v, ok := s.errorTemplates.Load(filename); ok {
+				if v == nil {
+					continue
+				}
+				return v.(Template)
+			}
+		}
+		tpl, err := ParseHtmlTemplateFile(filename)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				// don't cache; the file may be fixed
+				s.LogError("error page template %s: %v", filename, err)
+				continue
+			}
+			s.errorTemplates.Store(filename, nil)
+			continue
+		}
+		tpl.Funcs(s.TemplateHelpers())
+		s.errorTemplates.Store(filename, tpl)
+		return tpl
+	}
+	return nil
+}
+
+// RespondWithMessage responds with statusCode and an optional public message, which is
+// formatted with fmt.Sprint. The response is written by t.Server.ErrorRenderer or
+// DefaultErrorRenderer.
+func (t *Transaction) RespondWithMessage(statusCode int, msg interface{}) {
+	message := ""
+	if msg != nil {
+		message = fmt.Sprint(msg)
+	}
+	render := DefaultErrorRenderer
+	if t.Server != nil && t.Server.ErrorRenderer != nil {
+		render = t.Server.ErrorRenderer
+	}
+	render(t, statusCode, message)
+}
+
+// fileErrorWriter is used when serving files to render error responses, like
+// "404 Not Found", with RespondWithStatus rather than as the plain text of net/http.
+type fileErrorWriter struct {
+	http.ResponseWriter
+	t           *Transaction
+	intercepted bool // true after an error status was written; the body is discarded
+}
+
+func (w *fileErrorWriter) WriteHeader(status int) {
+	if w.intercepted {
+		return
+	}
+	if status >= 400 {
+		w.intercepted = true
+		h := w.Header()
+		h.Del("Content-Type")
+		h.Del("X-Content-Type-Options")
+		w.t.RespondWithStatus(status)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *fileErrorWriter) Write(p []byte) (int, error) {
+	if w.intercepted {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
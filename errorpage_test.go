@@ -0,0 +1,91 @@
+package httpd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestDefaultErrorRenderer(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"404.html":   "<p>missing {{.Status}} {{.Message}}</p>",
+		"error.html": "<p>oops {{.StatusText}}</p>",
+		"file.txt":   "file",
+	} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		assert.NoErr(name, err)
+	}
+	s := NewServer(dir, "")
+	s.HandleFunc("/forbidden", func(t *Transaction) { t.RespondWithStatusForbidden() })
+	s.HandleErrorFunc("/user", func(t *Transaction) error {
+		return NewHTTPError(404, "no such user", nil)
+	})
+
+	w := serve(s, "GET", "/nope", nil)
+	assert.Eq("file server 404", w.Code, 404)
+	assert.Eq("file server 404 page", w.Body.String(), "<p>missing 404 </p>")
+	w = serve(s, "GET", "/file.txt", nil)
+	assert.Eq("file", w.Body.String(), "file")
+	w = serve(s, "GET", "/forbidden", nil)
+	assert.Eq("error.html", w.Code, 403)
+	assert.Eq("error.html page", w.Body.String(), "<p>oops Forbidden</p>")
+	assert.Eq("error.html Content-Type", w.Header().Get("Content-Type"),
+		"text/html; charset=utf-8")
+
+	w = serve(s, "GET", "/user", nil)
+	assert.Eq("404.html with message", w.Body.String(), "<p>missing 404 no such user</p>")
+	w = serve(s, "GET", "/user", nil, "Accept", "application/json")
+	assert.Eq("JSON status", w.Code, 404)
+	assert.Eq("JSON", w.Body.String(),
+		`{"status":404,"error":"Not Found","message":"no such user"}`+"\n")
+	assert.Eq("JSON Content-Type", w.Header().Get("Content-Type"),
+		"application/json; charset=utf-8")
+
+	// built-in page
+	s = NewServer("", "")
+	w = serve(s, "GET", "/<script>", nil)
+	assert.Eq("built-in page status", w.Code, 404)
+	assert.Ok("built-in page", strings.Contains(w.Body.String(), "Not Found"))
+	assert.Ok("built-in page does not echo the path", !strings.Contains(w.Body.String(), "script"))
+}
+
+func TestServerErrorRenderer(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.ErrorRenderer = func(t *Transaction, status int, message string) {
+		t.Status = status
+		t.WriteString("custom " + message)
+	}
+	s.HandleErrorFunc("/user", func(t *Transaction) error {
+		return NewHTTPError(410, "user was deleted", nil)
+	})
+	w := serve(s, "GET", "/nope", nil)
+	assert.Eq("status", w.Code, 404)
+	assert.Eq("body", w.Body.String(), "custom ")
+	w = serve(s, "GET", "/user", nil)
+	assert.Eq("HTTPError status", w.Code, 410)
+	assert.Eq("HTTPError body", w.Body.String(), "custom user was deleted")
+}
+
+func TestErrorPageRouteURL(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "error.html"),
+		[]byte(`<a href="{{routeurl "home"}}">home</a>`), 0644)
+	assert.NoErr("WriteFile", err)
+
+	// servers sharing a PubDir each use their own routes
+	for _, path := range []string{"/a", "/b"} {
+		s := NewServer(dir, "")
+		r, _ := s.Routes.HandleFunc(path, func(t *Transaction) {})
+		r.Name = "home"
+		w := serve(s, "GET", "/nope", nil)
+		assert.Eq("status", w.Code, 404)
+		assert.Eq("page", w.Body.String(), `<a href="`+path+`">home</a>`)
+	}
+}
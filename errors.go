@@ -0,0 +1,112 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+)
+
+// HTTPError is an error with a HTTP status code. Message is shown to the client while Cause
+// is only logged. Handlers can return a HTTPError from an ErrorHandlerFunc, or pass it to
+// Transaction.RespondWithError. Example:
+//
+//   if !found {
+//     return httpd.NewHTTPError(404, "no such user", nil)
+//   }
+//   if err := db.Save(user); err != nil {
+//     return httpd.NewHTTPError(503, "please try again later", err)
+//   }
+//
+type HTTPError struct {
+	Status  int    // HTTP status code, e.g. 404
+	Message string // public message; http.StatusText(Status) if empty
+	Cause   error  // internal cause; logged but not shown to the client (may be nil)
+}
+
+// NewHTTPError returns a HTTPError. cause may be nil.
+func NewHTTPError(status int, message string, cause error) *HTTPError {
+	return &HTTPError{Status: status, Message: message, Cause: cause}
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.PublicMessage()
+	if e.Cause != nil {
+		return msg + ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *HTTPError) Unwrap() error { return e.Cause }
+
+// PublicMessage returns Message, or the text of Status if Message is empty
+func (e *HTTPError) PublicMessage() string {
+	if e.Message == "" {
+		return http.StatusText(e.Status)
+	}
+	return e.Message
+}
+
+// ErrorHandlerFunc is a handler function which returns an error.
+// A non-nil error is passed to Transaction.RespondWithError. Example:
+//
+//   s.Handle("/user/{id:int}", httpd.ErrorHandlerFunc(func(t *httpd.Transaction) error {
+//     user, err := loadUser(t.RouteVarInt("id"))
+//     if err != nil {
+//       return err
+//     }
+//     return t.WriteJSON(200, user)
+//   }))
+//
+type ErrorHandlerFunc func(*Transaction) error
+
+func (f ErrorHandlerFunc) ServeHTTP(t *Transaction) {
+	if err := f(t); err != nil {
+		t.RespondWithError(err)
+	}
+}
+
+// RespondWithError responds with an error and logs it.
+//
+// If err is or wraps a HTTPError, the response has its status and public message and its
+// cause is logged. ValidationErrors (see Bind) result in "400 Bad Request". Any other error is
+// logged and results in "500 Internal Server Error"; the message of such an error is only
+// included in the response when DevMode is true.
+//
+// If the response has already been started, for instance by ReadJSON (which responds to
+// invalid requests itself) or by a handler which failed halfway through writing a response,
+// the error is only logged.
+func (t *Transaction) RespondWithError(err error) {
+	status := 500
+	var msg interface{}
+	var herr *HTTPError
+	var verrs ValidationErrors
+	if errors.As(err, &herr) {
+		status = herr.Status
+		msg = herr.PublicMessage()
+		if herr.Cause != nil {
+			t.logError(status, herr.Cause)
+		}
+	} else if errors.As(err, &verrs) {
+		status = 400
+		msg = verrs.Error()
+	} else {
+		t.logError(status, err)
+		if DevMode {
+			msg = err
+		}
+	}
+	if t.headerWritten || t.headBodySize > 0 || t.wroteMiddlewareHeader() {
+		return
+	}
+	t.headOnly = false // respond directly rather than deferring the header
+	t.RespondWithMessage(status, msg)
+}
+
+// logError logs an error which occurred while serving t. Errors of responses with a status
+// below 500 are logged as info since they are usually caused by clients.
+func (t *Transaction) logError(status int, err error) {
+	if status < 500 {
+		t.Server.LogInfo("%s %s: %v", t.Request.Method, t.URL.Path, err)
+	} else {
+		t.Server.LogError("%s %s: %v", t.Request.Method, t.URL.Path, err)
+	}
+}
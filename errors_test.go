@@ -0,0 +1,81 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestHTTPError(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	cause := errors.New("connection refused")
+	err := NewHTTPError(503, "", cause)
+	assert.Eq("Error", err.Error(), "Service Unavailable: connection refused")
+	assert.Eq("PublicMessage", err.PublicMessage(), "Service Unavailable")
+	assert.Ok("Unwrap", errors.Is(err, cause))
+	err = NewHTTPError(404, "no such user", nil)
+	assert.Eq("Error without cause", err.Error(), "no such user")
+}
+
+func TestTransactionRespondWithError(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	logbuf := captureLog(s)
+	s.HandleErrorFunc("/http", func(t *Transaction) error {
+		return NewHTTPError(404, "no such thing", errors.New("db: no rows"))
+	})
+	s.HandleErrorFunc("/wrapped", func(t *Transaction) error {
+		return fmt.Errorf("wrapped: %w", NewHTTPError(409, "", nil))
+	})
+	s.HandleErrorFunc("/internal", func(t *Transaction) error {
+		return errors.New("secret detail")
+	})
+	s.HandleErrorFunc("POST /json", func(t *Transaction) error {
+		var v int
+		return t.ReadJSON(&v) // responds itself
+	})
+	s.HandleErrorFunc("/partial", func(t *Transaction) error {
+		t.WriteString("partial")
+		return errors.New("write failed")
+	})
+	s.HandleFunc("/panic", func(t *Transaction) { panic("secret panic") })
+
+	w := serve(s, "GET", "/http", nil)
+	assert.Eq("HTTPError status", w.Code, 404)
+	assert.Ok("HTTPError message", strings.Contains(w.Body.String(), "no such thing"))
+	assert.Ok("HTTPError cause not shown", !strings.Contains(w.Body.String(), "db:"))
+	assert.Ok("HTTPError cause logged as info",
+		strings.Contains(logbuf.String(), "[info] GET /http: db: no rows"))
+
+	w = serve(s, "GET", "/wrapped", nil)
+	assert.Eq("wrapped HTTPError status", w.Code, 409)
+	assert.Ok("wrapped HTTPError message", strings.Contains(w.Body.String(), "Conflict"))
+
+	logbuf.Reset()
+	w = serve(s, "GET", "/internal", nil)
+	assert.Eq("internal error status", w.Code, 500)
+	assert.Ok("internal error not shown", !strings.Contains(w.Body.String(), "secret"))
+	assert.Ok("internal error logged as error",
+		strings.Contains(logbuf.String(), "[error] GET /internal: secret detail"))
+
+	w = serve(s, "POST", "/json", strings.NewReader("x"), "Content-Type", "application/json")
+	assert.Eq("ReadJSON error status", w.Code, 400)
+	assert.Eq("ReadJSON error responds once", strings.Count(w.Body.String(), "<body>"), 1)
+
+	w = serve(s, "GET", "/partial", nil)
+	assert.Eq("error after writing", w.Code, 200)
+	assert.Eq("error after writing body", w.Body.String(), "partial")
+
+	w = serve(s, "GET", "/panic", nil)
+	assert.Eq("panic status", w.Code, 500)
+	assert.Ok("panic not shown", !strings.Contains(w.Body.String(), "secret"))
+
+	DevMode = true
+	defer func() { DevMode = false }()
+	w = serve(s, "GET", "/internal", nil)
+	assert.Eq("internal error status in DevMode", w.Code, 500)
+	assert.Ok("internal error shown in DevMode", strings.Contains(w.Body.String(), "secret"))
+}
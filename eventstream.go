@@ -0,0 +1,186 @@
+package httpd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEventStreamHeartbeat is the initial heartbeat interval of an EventStream.
+// Zero disables heartbeats.
+var DefaultEventStreamHeartbeat = 15 * time.Second
+
+// ErrEventStreamClosed is returned by EventStream.Send after the stream has ended
+var ErrEventStreamClosed = errors.New("event stream closed")
+
+// Event is a server-sent event. See EventStream.Send
+type Event struct {
+	ID    string        // sets the client's last event ID, sent as Last-Event-ID on reconnect
+	Event string        // event type; "message" when empty
+	Data  string        // may contain multiple lines
+	Retry time.Duration // tells the client how long to wait before reconnecting, if non-zero
+}
+
+// EventStream writes server-sent events ("text/event-stream") to a client.
+// See Transaction.EventStream
+type EventStream struct {
+	// LastEventID is the ID of the last event received by a reconnecting client, from the
+	// Last-Event-ID request header. It's empty for new clients.
+	LastEventID string
+
+	t         *Transaction
+	mu        sync.Mutex // protects writes and the fields below
+	closed    bool
+	done      chan struct{}
+	heartbeat *time.Ticker
+}
+
+// EventStream starts a stream of server-sent events as the response to the request.
+// The response headers are sent right away and the read and write timeouts of the server
+// (Server.Server.ReadTimeout and WriteTimeout) are disabled for the connection.
+//
+// A comment is sent every DefaultEventStreamHeartbeat to keep the connection alive; see
+// SetHeartbeat. The stream ends when the client disconnects, when Server.Shutdown is called or
+// when Close is called. Handlers should return when Done is closed and must call Close before
+// returning, since nothing may be written after a handler returns. Example:
+//
+//   es, err := t.EventStream()
+//   if err != nil {
+//     return err
+//   }
+//   defer es.Close()
+//   updates := subscribe(es.LastEventID)
+//   for {
+//     select {
+//     case <-es.Done():
+//       return nil
+//     case u := <-updates:
+//       es.Send(httpd.Event{ID: u.ID, Event: "update", Data: u.JSON})
+//     }
+//   }
+//
+// An error is returned if the response can't be streamed, i.e. if the ResponseWriter of t
+// is not a http.Flusher.
+func (t *Transaction) EventStream() (*EventStream, error) {
+	if _, ok := t.ResponseWriter.(http.Flusher); !ok {
+		return nil, errors.New("EventStream: response writer does not support flushing")
+	}
+	s := &EventStream{
+		LastEventID: t.Request.Header.Get("Last-Event-ID"),
+		t:           t,
+		done:        make(chan struct{}),
+		heartbeat:   time.NewTicker(time.Hour), // see SetHeartbeat
+	}
+	s.SetHeartbeat(DefaultEventStreamHeartbeat)
+	h := t.Header()
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // disable buffering by proxies like nginx
+	h.Del("Content-Length")
+	t.headOnly = false
+	t.noCompress = true
+	t.Status = 200
+	t.Flush()
+
+	if conn, ok := t.Request.Context().Value(connKey{}).(net.Conn); ok {
+		// net/http sets deadlines for each request, so they apply until the handler returns
+		conn.SetReadDeadline(time.Time{})
+		conn.SetWriteDeadline(time.Time{})
+	}
+
+	var shutdown <-chan struct{}
+	if t.Server != nil {
+		shutdown = t.Server.shutdownChan()
+	}
+	go s.run(t.Request.Context(), shutdown)
+	return s, nil
+}
+
+func (s *EventStream) run(ctx context.Context, shutdown <-chan struct{}) {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ctx.Done(): // client disconnected
+			s.Close()
+			return
+		case <-shutdown:
+			s.Close()
+			return
+		case <-s.heartbeat.C:
+			if s.write(":\n\n") != nil {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// Done returns a channel which is closed when the stream has ended
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream. It's safe to call Close more than once and from any goroutine.
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.heartbeat.Stop()
+		close(s.done)
+	}
+}
+
+// SetHeartbeat changes the interval at which heartbeat comments are sent.
+// A zero or negative interval disables heartbeats.
+func (s *EventStream) SetHeartbeat(d time.Duration) {
+	if d <= 0 {
+		s.heartbeat.Stop()
+		return
+	}
+	s.heartbeat.Reset(d)
+}
+
+// Send writes an event to the client. It's safe to call Send from any goroutine.
+// ErrEventStreamClosed is returned if the stream has ended. ID and Event must not contain
+// line breaks.
+func (s *EventStream) Send(e Event) error {
+	if strings.ContainsAny(e.ID, "\r\n") || strings.ContainsAny(e.Event, "\r\n") {
+		return errors.New("EventStream: line break in event ID or type")
+	}
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(int64(e.Retry/time.Millisecond), 10) + "\n")
+	}
+	data := strings.ReplaceAll(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+func (s *EventStream) write(frame string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrEventStreamClosed
+	}
+	if _, err := s.t.WriteString(frame); err != nil {
+		return err
+	}
+	s.t.Flush()
+	return nil
+}
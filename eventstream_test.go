@@ -0,0 +1,151 @@
+package httpd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestEventStream(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.HandleErrorFunc("/events", func(t *Transaction) error {
+		es, err := t.EventStream()
+		if err != nil {
+			return err
+		}
+		assert.Eq("LastEventID", es.LastEventID, "41")
+		es.Send(Event{ID: "42", Event: "update", Data: "a\r\nb\rc", Retry: 3 * time.Second})
+		es.Send(Event{Data: strings.Repeat("x", 2000)})
+		assert.Err("line break", "line break", es.Send(Event{Event: "a\nb"}))
+		es.Close()
+		es.Close()
+		_, open := <-es.Done()
+		assert.Ok("Done is closed after Close", !open)
+		assert.Eq("Send after Close", es.Send(Event{Data: "x"}), ErrEventStreamClosed)
+		return nil
+	})
+	w := serve(s, "GET", "/events", nil, "Last-Event-ID", "41", "Accept-Encoding", "gzip")
+	assert.Eq("status", w.Code, 200)
+	h := w.Header()
+	assert.Eq("Content-Type", h.Get("Content-Type"), "text/event-stream; charset=utf-8")
+	assert.Eq("Cache-Control", h.Get("Cache-Control"), "no-cache")
+	assert.Eq("Content-Encoding", h.Get("Content-Encoding"), "")
+	assert.Ok("flushed", w.Flushed)
+	assert.Eq("body", w.Body.String(),
+		"id: 42\nevent: update\nretry: 3000\ndata: a\ndata: b\ndata: c\n\n"+
+			"data: "+strings.Repeat("x", 2000)+"\n\n")
+
+	// a ResponseWriter which isn't a http.Flusher can't stream
+	s.HandleErrorFunc("/noflush", func(t *Transaction) error {
+		_, err := t.EventStream()
+		assert.Err("no http.Flusher", "does not support flushing", err)
+		return nil
+	})
+	w = httptest.NewRecorder()
+	s.ServeHTTP(struct{ http.ResponseWriter }{w}, httptest.NewRequest("GET", "/noflush", nil))
+}
+
+func TestEventStreamHeartbeat(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var heartbeat *time.Duration
+	s.HandleErrorFunc("/events", func(t *Transaction) error {
+		es, err := t.EventStream()
+		if err != nil {
+			return err
+		}
+		if heartbeat != nil {
+			es.SetHeartbeat(*heartbeat)
+		}
+		time.Sleep(50 * time.Millisecond)
+		es.Close()
+		return nil
+	})
+	for _, d := range []time.Duration{0, -1} {
+		heartbeat = &d
+		w := serve(s, "GET", "/events", nil)
+		assert.Eq(fmt.Sprintf("heartbeat %v", d), w.Body.String(), "")
+	}
+	d := 5 * time.Millisecond
+	heartbeat = &d
+	w := serve(s, "GET", "/events", nil)
+	assert.Ok("heartbeats: "+w.Body.String(), strings.HasPrefix(w.Body.String(), ":\n\n:\n\n"))
+
+	prev := DefaultEventStreamHeartbeat
+	defer func() { DefaultEventStreamHeartbeat = prev }()
+	DefaultEventStreamHeartbeat = 0
+	heartbeat = nil
+	w = serve(s, "GET", "/events", nil)
+	assert.Eq("DefaultEventStreamHeartbeat 0", w.Body.String(), "")
+}
+
+func TestEventStreamServer(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.Gotalk = nil
+	captureLog(s)
+	s.Server.ReadTimeout = 100 * time.Millisecond
+	s.Server.WriteTimeout = 100 * time.Millisecond
+	ended := make(chan struct{})
+	s.HandleErrorFunc("/events", func(t *Transaction) error {
+		es, err := t.EventStream()
+		if err != nil {
+			return err
+		}
+		defer es.Close()
+		es.SetHeartbeat(50 * time.Millisecond)
+		for i := 1; ; i++ {
+			select {
+			case <-es.Done():
+				close(ended)
+				return nil
+			case <-time.After(120 * time.Millisecond):
+				es.Send(Event{ID: strings.Repeat("i", i)})
+			}
+		}
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	res, err := http.Get("http://" + ln.Addr().String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// events are still received after the server's write timeout
+	r := bufio.NewReader(res.Body)
+	var heartbeats, events int
+	for events < 3 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		switch line {
+		case ":\n":
+			heartbeats++
+		case "id: " + strings.Repeat("i", events+1) + "\n":
+			events++
+		}
+	}
+	assert.Ok("heartbeats", heartbeats > 0)
+
+	// Shutdown ends the stream
+	go s.Shutdown(context.Background(), nil)
+	select {
+	case <-ended:
+	case <-time.After(2 * time.Second):
+		t.Errorf("stream did not end on Shutdown")
+	}
+}
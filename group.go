@@ -0,0 +1,85 @@
+package httpd
+
+import (
+	"fmt"
+
+	"github.com/rsms/go-httpd/route"
+)
+
+// Group is a set of routes of a Router which share conditions and middleware
+type Group struct {
+	router     *Router
+	conditions route.CondFlags
+	middleware []Middleware
+}
+
+// Group creates a route group. Routes added to the group without conditions of their own get
+// the group's conditions, while routes with conditions must be limited to those of the group.
+// A conditions value of zero means "any method". middleware is applied to all routes of the
+// group, after the middleware of the router.
+//
+// Example:
+//
+//   api := s.Routes.Group(route.CondMethodGET|route.CondMethodPOST, requireAuth)
+//   api.HandleFunc("/api/user/{id}", handleUser)     // GET, HEAD and POST
+//   api.HandleFunc("POST /api/logout", handleLogout) // only POST
+//
+func (r *Router) Group(conditions route.CondFlags, middleware ...Middleware) *Group {
+	return &Group{router: r, conditions: conditions, middleware: middleware}
+}
+
+// Group creates a nested group with the conditions of g limited to conditions and with
+// middleware added after the middleware of g.
+func (g *Group) Group(conditions route.CondFlags, middleware ...Middleware) *Group {
+	if g.conditions != 0 {
+		if conditions == 0 {
+			conditions = g.conditions
+		} else {
+			conditions &= g.conditions
+		}
+	}
+	g2 := &Group{router: g.router, conditions: conditions}
+	g2.middleware = append(g2.middleware, g.middleware...)
+	g2.middleware = append(g2.middleware, middleware...)
+	return g2
+}
+
+// Use adds middleware to the group. It only affects routes added after the call.
+func (g *Group) Use(middleware ...Middleware) {
+	g.middleware = append(g.middleware, middleware...)
+}
+
+func (g *Group) HandleFunc(pattern string, f func(*Transaction)) (*Route, error) {
+	return g.Handle(pattern, handlerFunc(f))
+}
+
+func (g *Group) HandleErrorFunc(pattern string, f func(*Transaction) error) (*Route, error) {
+	return g.Handle(pattern, ErrorHandlerFunc(f))
+}
+
+func (g *Group) Handle(pattern string, handler Handler) (*Route, error) {
+	if g.conditions != 0 {
+		var probe route.Route
+		if err := probe.Parse(pattern); err != nil {
+			return nil, err
+		}
+		if probe.Conditions == 0 {
+			pattern = g.conditions.String() + " " + pattern
+		} else {
+			allowed := g.conditions
+			if allowed&route.CondMethodGET != 0 {
+				allowed |= route.CondMethodHEAD
+			}
+			if probe.Conditions&^allowed != 0 {
+				return nil, fmt.Errorf("conditions of route %q exceed those of its group (%s)",
+					pattern, g.conditions)
+			}
+		}
+	}
+	r, err := g.router.Handle(pattern, handler)
+	if r != nil {
+		// group middleware runs before any middleware added to the route later
+		r.Use(g.middleware...)
+	}
+	return r, err
+}
@@ -0,0 +1,99 @@
+package httpd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-httpd/route"
+	"github.com/rsms/go-testutil"
+)
+
+func TestGroup(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return handlerFunc(func(t *Transaction) {
+				calls = append(calls, name)
+				next.ServeHTTP(t)
+			})
+		}
+	}
+	handler := func(t *Transaction) { calls = append(calls, "handler") }
+
+	api := s.Routes.Group(route.CondMethodGET|route.CondMethodPOST, mw("api"))
+	_, err := api.HandleFunc("/user", handler)
+	assert.NoErr("route without conditions", err)
+	_, err = api.HandleFunc("POST /logout", handler)
+	assert.NoErr("route with a subset of the conditions", err)
+	_, err = api.HandleFunc("HEAD /ping", handler)
+	assert.NoErr("HEAD is allowed by GET", err)
+	_, err = api.HandleFunc("PUT /user", handler)
+	assert.Err("conditions exceed those of the group", "exceed those of its group", err)
+	_, err = api.HandleFunc("FOO /user", handler)
+	assert.Err("invalid pattern", "unknown method", err)
+
+	api.Use(mw("late"))
+	_, err = api.HandleErrorFunc("/err", func(t *Transaction) error {
+		calls = append(calls, "handler")
+		return NewHTTPError(409, "conflict", nil)
+	})
+	assert.NoErr("HandleErrorFunc", err)
+
+	admin := api.Group(route.CondMethodPOST|route.CondMethodPUT, mw("admin"))
+	_, err = admin.HandleFunc("PUT /admin", handler)
+	assert.Err("nested conditions are limited to those of the parent", "exceed", err)
+	_, err = admin.HandleFunc("/admin", handler)
+	assert.NoErr("nested group route", err)
+	_, err = api.Group(0).HandleFunc("/any", handler)
+	assert.NoErr("nested group with the conditions of the parent", err)
+
+	for _, test := range []struct {
+		method, path string
+		status       int
+		calls        string
+	}{
+		{"GET", "/user", 200, "api handler"},
+		{"POST", "/user", 200, "api handler"},
+		{"HEAD", "/user", 200, "api handler"},
+		{"PUT", "/user", 405, ""},
+		{"GET", "/logout", 405, ""},
+		{"POST", "/logout", 200, "api handler"},
+		{"HEAD", "/ping", 200, "api handler"},
+		{"GET", "/err", 409, "api late handler"},
+		{"POST", "/admin", 200, "api late admin handler"},
+		{"GET", "/admin", 405, ""},
+		{"PUT", "/admin", 405, ""},
+		{"GET", "/any", 200, "api late handler"},
+		{"PUT", "/any", 405, ""},
+	} {
+		calls = nil
+		w := serve(s, test.method, test.path, nil)
+		name := test.method + " " + test.path
+		assert.Eq(name+" status", w.Code, test.status)
+		assert.Eq(name+" calls", strings.Join(calls, " "), test.calls)
+	}
+}
+
+func TestGroupRouteMiddleware(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var calls []string
+	g := s.Routes.Group(0, func(next Handler) Handler {
+		return handlerFunc(func(t *Transaction) {
+			calls = append(calls, "group")
+			next.ServeHTTP(t)
+		})
+	})
+	r, _ := g.HandleFunc("/a", func(t *Transaction) { calls = append(calls, "handler") })
+	r.Use(func(next Handler) Handler {
+		return handlerFunc(func(t *Transaction) {
+			calls = append(calls, "route")
+			next.ServeHTTP(t)
+		})
+	})
+	serve(s, "DELETE", "/a", nil)
+	assert.Eq("group middleware runs before route middleware",
+		strings.Join(calls, " "), "group route handler")
+}
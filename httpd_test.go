@@ -0,0 +1,32 @@
+package httpd
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+
+	"github.com/rsms/go-log"
+)
+
+// serve sends a request to s and returns the recorded response.
+// hdr is a list of header names and values.
+func serve(
+	s *Server, method, target string, body io.Reader, hdr ...string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	for i := 0; i+1 < len(hdr); i += 2 {
+		req.Header.Set(hdr[i], hdr[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w
+}
+
+// captureLog makes s log to the returned buffer, with messages prefixed by their level,
+// e.g. "[error] "
+func captureLog(s *Server) *bytes.Buffer {
+	var buf bytes.Buffer
+	s.Logger = log.NewLogger(&buf, "", log.LevelInfo,
+		log.FPrefixInfo|log.FPrefixWarn|log.FPrefixError|log.FSync)
+	return &buf
+}
@@ -0,0 +1,157 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// DefaultMaxJSONBodySize is the limit of request bodies read by Transaction.ReadJSON when
+// Server.MaxJSONBodySize is zero
+const DefaultMaxJSONBodySize = 1 << 20 // 1MB
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// ReadJSON decodes the JSON request body into v, which should be a pointer.
+//
+// The request must have a JSON content type (e.g. "application/json" or
+// "application/ld+json") and a body of at most t.Server.MaxJSONBodySize bytes containing a
+// single JSON value. Unknown object fields are an error when t.Server.StrictJSON is true.
+//
+// If the request can't be decoded, a response is sent with the status
+// "415 Unsupported Media Type", "413 Request Entity Too Large" or "400 Bad Request" and a
+// *HTTPError describing the problem is returned. Example:
+//
+//   var req struct { Name string }
+//   if err := t.ReadJSON(&req); err != nil {
+//     return
+//   }
+//
+func (t *Transaction) ReadJSON(v interface{}) error {
+	if err := t.readJSON(v); err != nil {
+		t.RespondWithMessage(err.Status, err.Message)
+		return err
+	}
+	return nil
+}
+
+func (t *Transaction) readJSON(v interface{}) *HTTPError {
+	ctype := mediaType(t.Request.Header.Get("Content-Type"))
+	if ctype != "application/json" && !strings.HasSuffix(ctype, "+json") {
+		return NewHTTPError(415, fmt.Sprintf("expected JSON content type, got %q", ctype), nil)
+	}
+	limit := int64(DefaultMaxJSONBodySize)
+	strict := false
+	if t.Server != nil {
+		if t.Server.MaxJSONBodySize > 0 {
+			limit = t.Server.MaxJSONBodySize
+		}
+		strict = t.Server.StrictJSON
+	}
+	if t.Request.ContentLength > limit {
+		return NewHTTPError(413, errBodyTooLarge.Error(), nil)
+	}
+	if t.Request.Body == nil {
+		return NewHTTPError(400, "empty request body", nil)
+	}
+	dec := json.NewDecoder(&limitedReader{r: t.Request.Body, n: limit})
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		switch err {
+		case errBodyTooLarge:
+			return NewHTTPError(413, err.Error(), nil)
+		case io.EOF:
+			return NewHTTPError(400, "empty request body", nil)
+		case io.ErrUnexpectedEOF:
+			return NewHTTPError(400, "incomplete JSON in request body", nil)
+		}
+		return NewHTTPError(400, "invalid JSON in request body: "+err.Error(), nil)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == errBodyTooLarge {
+			return NewHTTPError(413, err.Error(), nil)
+		}
+		return NewHTTPError(400, "unexpected data after JSON value in request body", nil)
+	}
+	return nil
+}
+
+// limitedReader reads from r until n bytes have been read and then fails with errBodyTooLarge
+type limitedReader struct {
+	r io.Reader
+	n int64 // remaining bytes
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1] // read one byte past the limit to detect an oversized body
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// WriteJSON writes v as JSON with the status code status.
+// The output is indented when DevMode is true.
+//
+// If v is a channel, its values are streamed as a JSON array: each value is encoded and
+// written as it's received, until the channel is closed, and the response is flushed after
+// each value. This allows large collections to be written without holding them in memory:
+//
+//   rows := make(chan *Row)
+//   go loadRows(rows) // closes rows when done
+//   t.WriteJSON(200, rows)
+//
+// Any other value is encoded in its entirety before it's written, so nothing is written if
+// encoding fails. When streaming a channel, an error may occur after part of the response has
+// been sent.
+func (t *Transaction) WriteJSON(status int, v interface{}) error {
+	t.Header().Set("Content-Type", "application/json; charset=utf-8")
+	t.Status = status
+	enc := json.NewEncoder(t)
+	if DevMode {
+		enc.SetIndent("", "  ")
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Chan && rv.Type().ChanDir()&reflect.RecvDir != 0 {
+		return t.writeJSONChan(enc, rv)
+	}
+	return enc.Encode(v)
+}
+
+func (t *Transaction) writeJSONChan(enc *json.Encoder, ch reflect.Value) error {
+	sep := "["
+	for {
+		v, ok := ch.Recv()
+		if !ok {
+			break
+		}
+		if _, err := t.WriteString(sep); err != nil {
+			return err
+		}
+		if err := enc.Encode(v.Interface()); err != nil {
+			return err
+		}
+		if !t.headOnly {
+			t.Flush()
+		}
+		sep = ","
+	}
+	if sep == "[" {
+		_, err := t.WriteString("[]\n")
+		return err
+	}
+	_, err := t.WriteString("]\n")
+	return err
+}
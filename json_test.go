@@ -0,0 +1,93 @@
+package httpd
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestTransactionReadJSON(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.MaxJSONBodySize = 20
+	s.HandleFunc("POST /", func(t *Transaction) {
+		var v struct{ A int }
+		if err := t.ReadJSON(&v); err != nil {
+			return
+		}
+		t.WriteJSON(201, v)
+	})
+
+	const ctype = "application/json"
+	for _, c := range []struct {
+		body, ctype string
+		status      int
+	}{
+		{`{"A":1}`, ctype, 201},
+		{`{"A":1}  `, "application/ld+json; charset=utf-8", 201},
+		{`{"A":1,"B":2}`, ctype, 201}, // unknown fields are ignored unless StrictJSON is set
+		{`{"A":1}`, "text/plain", 415},
+		{`{"A":1}`, "", 415},
+		{`{"A":1} x`, ctype, 400},
+		{`{"A":`, ctype, 400},
+		{``, ctype, 400},
+		{`{"A":"x"}`, ctype, 400},
+		{`{"A":1,          "B":2}`, ctype, 413},
+	} {
+		w := serve(s, "POST", "/", strings.NewReader(c.body), "Content-Type", c.ctype)
+		assert.Eq(c.body+" "+c.ctype, w.Code, c.status)
+		if c.status == 201 {
+			assert.Eq(c.body+" response", w.Body.String(), "{\"A\":1}\n")
+		}
+	}
+
+	// a body larger than the limit, without Content-Length
+	body := io.MultiReader(strings.NewReader(`{"A":1,          "B":2}`))
+	req := httptest.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", ctype)
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	assert.Eq("too large without Content-Length", w.Code, 413)
+
+	s.StrictJSON = true
+	w = serve(s, "POST", "/", strings.NewReader(`{"A":1,"B":2}`), "Content-Type", ctype)
+	assert.Eq("unknown field with StrictJSON", w.Code, 400)
+}
+
+func TestTransactionWriteJSON(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.HandleFunc("/chan", func(t *Transaction) {
+		ch := make(chan int)
+		go func() {
+			ch <- 1
+			ch <- 2
+			close(ch)
+		}()
+		t.WriteJSON(200, ch)
+	})
+	s.HandleFunc("/empty", func(t *Transaction) {
+		ch := make(chan string)
+		close(ch)
+		t.WriteJSON(200, ch)
+	})
+	s.HandleErrorFunc("/invalid", func(t *Transaction) error {
+		return t.WriteJSON(200, map[string]interface{}{"f": func() {}})
+	})
+
+	w := serve(s, "GET", "/chan", nil)
+	assert.Eq("channel", w.Body.String(), "[1\n,2\n]\n")
+	assert.Eq("channel Content-Type", w.Header().Get("Content-Type"),
+		"application/json; charset=utf-8")
+	assert.Ok("channel is flushed", w.Flushed)
+	w = serve(s, "GET", "/empty", nil)
+	assert.Eq("empty channel", w.Body.String(), "[]\n")
+
+	// a value which can't be encoded results in an error response, as nothing has been written
+	w = serve(s, "GET", "/invalid", nil)
+	assert.Eq("encoding error", w.Code, 500)
+}
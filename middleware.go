@@ -0,0 +1,127 @@
+package httpd
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rsms/go-httpd/route"
+)
+
+// Middleware wraps a handler, e.g. to check authorization or add headers, and returns a
+// handler which usually calls the wrapped handler.
+type Middleware func(Handler) Handler
+
+// Use adds middleware which is applied to all routes of r, including routes of mounted
+// routers. Middleware added first is outermost, i.e. it runs first.
+// Middleware is only applied to requests which match a route.
+//
+// Per-route middleware can be added with the Use method of the route returned by Handle.
+// Router middleware runs before route middleware.
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Use adds middleware to the route. Middleware of a mount point (see Router.Mount) runs
+// before the middleware of the mounted router.
+func (r *Route) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// chain wraps the handler of m in the middleware of its route, the middleware of the routers
+// which m was found in and the middleware of their mount points.
+func (r *Router) chain(m *route.Match) Handler {
+	var h Handler
+	if rt, ok := m.Route.Handler.(*Route); ok {
+		h = wrap(rt.handler, rt.middleware)
+	} else {
+		h = m.Route.Handler.(Handler) // added with route.Router.Add
+	}
+	for p := m.Parent; p != nil; p = p.Parent {
+		if mp, ok := p.Route.Handler.(*Route); ok {
+			h = wrap(wrap(h, mp.sub.middleware), mp.middleware)
+		}
+	}
+	return wrap(h, r.middleware)
+}
+
+func wrap(h Handler, middleware []Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+type transactionKey struct{}
+
+// HTTPMiddleware adapts standard net/http middleware, e.g. func(http.Handler) http.Handler, so
+// that it can be used with Router.Use and Route.Use.
+// Changes that the middleware makes to the request, like a new context, are visible to the
+// wrapped handler via t.Request, and a ResponseWriter provided by the middleware receives all
+// output of the wrapped handler.
+func HTTPMiddleware(m func(http.Handler) http.Handler) Middleware {
+	return func(next Handler) Handler {
+		h := m(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			t := req.Context().Value(transactionKey{}).(*Transaction)
+			if req.URL != t.URL {
+				t.URL = req.URL
+				t.query = nil
+			}
+			t.Request = req
+			if w != t {
+				// The middleware replaced the ResponseWriter, usually with one that writes to t.
+				// Output of the handler goes to w until it returns; see Transaction.nextWriter
+				t.writers = append(t.writers, &middlewareWriter{ResponseWriter: w})
+				defer t.popWriter()
+			}
+			next.ServeHTTP(t)
+		}))
+		return handlerFunc(func(t *Transaction) {
+			ctx := context.WithValue(t.Request.Context(), transactionKey{}, t)
+			h.ServeHTTP(t, t.Request.WithContext(ctx))
+		})
+	}
+}
+
+// middlewareWriter is a ResponseWriter which HTTPMiddleware passes the output of a handler to
+type middlewareWriter struct {
+	http.ResponseWriter
+	headerWritten bool
+}
+
+func (w *middlewareWriter) writeHeader(statusCode int) {
+	if !w.headerWritten {
+		w.headerWritten = true
+		w.ResponseWriter.WriteHeader(statusCode)
+	}
+}
+
+// nextWriter returns the middleware writer which output of t goes to, or nil if output goes to
+// t.ResponseWriter. Writes to t by a middleware writer go to the writer of the enclosing
+// middleware, and finally to t.ResponseWriter. The caller must call t.leaveWriter when done
+// writing to a non-nil writer.
+func (t *Transaction) nextWriter() *middlewareWriter {
+	if t.writerDepth == len(t.writers) {
+		return nil
+	}
+	t.writerDepth++
+	return t.writers[len(t.writers)-t.writerDepth]
+}
+
+func (t *Transaction) leaveWriter() {
+	t.writerDepth--
+}
+
+func (t *Transaction) popWriter() {
+	t.writers[len(t.writers)-1] = nil
+	t.writers = t.writers[:len(t.writers)-1]
+}
+
+// wroteMiddlewareHeader returns true if the header has been written to a middleware writer
+func (t *Transaction) wroteMiddlewareHeader() bool {
+	for _, w := range t.writers {
+		if w.headerWritten {
+			return true
+		}
+	}
+	return false
+}
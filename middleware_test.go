@@ -0,0 +1,175 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-httpd/route"
+	"github.com/rsms/go-testutil"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var calls []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return handlerFunc(func(t *Transaction) {
+				calls = append(calls, name)
+				next.ServeHTTP(t)
+			})
+		}
+	}
+	handler := func(t *Transaction) { calls = append(calls, "handler") }
+
+	s.Routes.Use(mw("router1"), mw("router2"))
+	r, _ := s.Routes.HandleFunc("/a", handler)
+	r.Use(mw("route"), func(next Handler) Handler { return next })
+
+	g := s.Routes.Group(route.CondMethodGET, mw("group"))
+	_, err := g.Group(0, mw("subgroup")).HandleFunc("/g", handler)
+	assert.NoErr("group route", err)
+	_, err = g.HandleFunc("POST /g2", handler)
+	assert.Err("route conditions outside of group", "exceed those of its group", err)
+
+	var sub Router
+	sub.Use(mw("sub"))
+	sub.HandleFunc("/b", handler)
+	m, _ := s.Routes.Mount("/sub/", &sub)
+	m.Use(mw("mount"))
+
+	for path, expect := range map[string]string{
+		"/a":     "router1 router2 route handler",
+		"/g":     "router1 router2 group subgroup handler",
+		"/sub/b": "router1 router2 mount sub handler",
+	} {
+		calls = nil
+		serve(s, "GET", path, nil)
+		assert.Eq(path, strings.Join(calls, " "), expect)
+	}
+
+	calls = nil
+	w := serve(s, "POST", "/g", nil)
+	assert.Eq("group method", w.Code, 405)
+	assert.Eq("no middleware for unmatched request", len(calls), 0)
+
+	// routes of a clone have their own middleware
+	err = s.UpdateRoutes(func(r2 *Router) error {
+		r2.Routes[0].Handler.(*Route).Use(mw("clone"))
+		return nil
+	})
+	assert.NoErr("UpdateRoutes", err)
+	calls = nil
+	serve(s, "GET", "/a", nil)
+	assert.Eq("clone", strings.Join(calls, " "), "router1 router2 route clone handler")
+	assert.Eq("middleware of original route", len(r.middleware), 2)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += len(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var counted int
+	var outer, inner *Transaction
+	s.Routes.Use(func(next Handler) Handler {
+		return handlerFunc(func(t *Transaction) {
+			outer = t
+			next.ServeHTTP(t)
+		})
+	})
+	s.Routes.Use(HTTPMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Middleware", "1")
+			cw := &countingWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, req.WithContext(req.Context()))
+			counted = cw.n
+		})
+	}))
+	s.HandleFunc("GET /hello", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteHeader(201)
+		t.WriteString("hello")
+	})
+
+	w := serve(s, "GET", "/hello", nil)
+	assert.Eq("status", w.Code, 201)
+	assert.Eq("body", w.Body.String(), "hello")
+	assert.Eq("middleware header", w.Header().Get("X-Middleware"), "1")
+	assert.Eq("bytes written through middleware writer", counted, 5)
+
+	// the body of a HEAD request is discarded after passing through the middleware writer
+	w = serve(s, "HEAD", "/hello", nil)
+	assert.Eq("HEAD status", w.Code, 201)
+	assert.Eq("HEAD body", w.Body.Len(), 0)
+	assert.Eq("HEAD Content-Length", w.Header().Get("Content-Length"), "5")
+	assert.Eq("HEAD bytes written through middleware writer", counted, 5)
+
+	// the handler is served the same transaction as the middleware
+	s.HandleFunc("/created", func(t *Transaction) {
+		inner = t
+		t.Status = 201
+		t.WriteString("created")
+	})
+	w = serve(s, "POST", "/created", nil)
+	assert.Eq("Status", w.Code, 201)
+	assert.Eq("body", w.Body.String(), "created")
+	assert.Ok("same transaction", inner != nil && inner == outer)
+
+	// no error page is written after the handler wrote to the middleware writer
+	s.HandleErrorFunc("/fail", func(t *Transaction) error {
+		t.WriteString("partial")
+		return errors.New("oops")
+	})
+	w = serve(s, "GET", "/fail", nil)
+	assert.Eq("error status", w.Code, 200)
+	assert.Eq("error body", w.Body.String(), "partial")
+}
+
+// prefixWriter writes a prefix before the first write of the body
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix string
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	if w.prefix != "" {
+		w.ResponseWriter.Write([]byte(w.prefix))
+		w.prefix = ""
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func TestHTTPMiddlewareNested(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	prefix := func(p string) Middleware {
+		return HTTPMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				next.ServeHTTP(&prefixWriter{ResponseWriter: w, prefix: p}, req)
+				w.Write([]byte("/" + p))
+			})
+		})
+	}
+	s.Routes.Use(prefix("a"))
+	r, _ := s.Routes.HandleFunc("/", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.WriteString(" hello ")
+	})
+	r.Use(prefix("b"))
+
+	w := serve(s, "GET", "/", nil)
+	assert.Eq("body", w.Body.String(), "ab hello /b/a")
+	assert.Eq("Content-Type", w.Header().Get("Content-Type"), "text/plain")
+}
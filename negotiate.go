@@ -0,0 +1,276 @@
+package httpd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Encoder writes values in a particular media type. See Transaction.Respond
+type Encoder interface {
+	// ContentType returns the value of the Content-Type header of encoded values,
+	// e.g. "application/json; charset=utf-8"
+	ContentType() string
+
+	// Encode writes v to w
+	Encode(w io.Writer, v interface{}) error
+}
+
+// ValueEncoder can be implemented by an Encoder which can only encode some values.
+// Encoders which return false from CanEncode are not considered when negotiating a response.
+type ValueEncoder interface {
+	Encoder
+	CanEncode(v interface{}) bool
+}
+
+// CSVMarshaler is implemented by values which can be encoded by CSVEncoder
+type CSVMarshaler interface {
+	MarshalCSV() ([][]string, error)
+}
+
+// ErrNotAcceptable is the cause of the error returned by Transaction.Respond when none of the
+// encoders produces a media type accepted by the request. Use errors.Is to test for it.
+// The error is a *HTTPError with status 406, so it's not logged as an error when returned
+// from an ErrorHandlerFunc.
+var ErrNotAcceptable = errors.New("no acceptable media type")
+
+var (
+	// JSONEncoder encodes any value as JSON
+	JSONEncoder Encoder = &encoder{"application/json; charset=utf-8", encodeJSON, nil}
+
+	// TextEncoder encodes strings, byte slices, errors and fmt.Stringers as plain text
+	TextEncoder Encoder = &encoder{"text/plain; charset=utf-8", encodeText, canEncodeText}
+
+	// CSVEncoder encodes [][]string values and CSVMarshalers as CSV
+	CSVEncoder Encoder = &encoder{"text/csv; charset=utf-8", encodeCSV, canEncodeCSV}
+)
+
+// DefaultEncoders are used by Transaction.Respond when Server.Encoders is nil
+var DefaultEncoders = []Encoder{JSONEncoder, TextEncoder, CSVEncoder}
+
+// HTMLTemplateEncoder returns an Encoder which encodes values by executing tpl with the
+// value as its data. Example:
+//
+//   t.Respond(user, httpd.HTMLTemplateEncoder(userTemplate))
+//
+func HTMLTemplateEncoder(tpl Template) Encoder {
+	return &encoder{"text/html; charset=utf-8", tpl.Exec, nil}
+}
+
+type encoder struct {
+	contentType string
+	encode      func(w io.Writer, v interface{}) error
+	canEncode   func(v interface{}) bool
+}
+
+func (e *encoder) ContentType() string                     { return e.contentType }
+func (e *encoder) Encode(w io.Writer, v interface{}) error { return e.encode(w, v) }
+
+func (e *encoder) CanEncode(v interface{}) bool {
+	return e.canEncode == nil || e.canEncode(v)
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func canEncodeText(v interface{}) bool {
+	switch v.(type) {
+	case string, []byte, error, fmt.Stringer:
+		return true
+	}
+	return false
+}
+
+func encodeText(w io.Writer, v interface{}) error {
+	var err error
+	switch v := v.(type) {
+	case []byte:
+		_, err = w.Write(v)
+	case string:
+		_, err = io.WriteString(w, v)
+	default:
+		_, err = fmt.Fprint(w, v)
+	}
+	return err
+}
+
+func canEncodeCSV(v interface{}) bool {
+	switch v.(type) {
+	case [][]string, CSVMarshaler:
+		return true
+	}
+	return false
+}
+
+func encodeCSV(w io.Writer, v interface{}) error {
+	var records [][]string
+	switch v := v.(type) {
+	case [][]string:
+		records = v
+	case CSVMarshaler:
+		var err error
+		if records, err = v.MarshalCSV(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("CSVEncoder: unsupported value of type %T", v)
+	}
+	return csv.NewWriter(w).WriteAll(records)
+}
+
+// Negotiate returns the media type of offers which is most preferred by the request's Accept
+// header, or "" if none of offers is acceptable. offers are media types like "text/html" and
+// ties are won by the offer which appears first. A request without an Accept header accepts
+// any media type, as does one with an invalid Accept header.
+func (t *Transaction) Negotiate(offers ...string) string {
+	_, i := negotiate(t.Request.Header.Values("Accept"), offers)
+	if i == -1 {
+		return ""
+	}
+	return offers[i]
+}
+
+// Respond writes v with t.Status using the encoder whose media type is most preferred by the
+// request's Accept header. encoders are considered before t.Server.Encoders (or
+// DefaultEncoders when that is nil) and ties are won by the encoder which appears first.
+// Content-Type is set to that of the encoder and "Accept" is added to the Vary header.
+//
+// If no encoder fits, the response is "406 Not Acceptable" and a *HTTPError wrapping
+// ErrNotAcceptable is returned.
+// Any other error is an encoding error, in which case nothing has been written.
+func (t *Transaction) Respond(v interface{}, encoders ...Encoder) error {
+	registered := DefaultEncoders
+	if t.Server != nil && t.Server.Encoders != nil {
+		registered = t.Server.Encoders
+	}
+	encoders = append(encoders[:len(encoders):len(encoders)], registered...)
+	var candidates []Encoder
+	var offers []string
+	for _, e := range encoders {
+		if ve, ok := e.(ValueEncoder); ok && !ve.CanEncode(v) {
+			continue
+		}
+		candidates = append(candidates, e)
+		offers = append(offers, mediaType(e.ContentType()))
+	}
+	t.addVary("Accept")
+	_, i := negotiate(t.Request.Header.Values("Accept"), offers)
+	if i == -1 {
+		t.RespondWithStatusNotAcceptable()
+		return NewHTTPError(406, "", ErrNotAcceptable)
+	}
+	var buf bytes.Buffer
+	if err := candidates[i].Encode(&buf, v); err != nil {
+		return err
+	}
+	t.Header().Set("Content-Type", candidates[i].ContentType())
+	t.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := t.Write(buf.Bytes())
+	return err
+}
+
+// addVary adds name to the Vary header unless it's already listed
+func (t *Transaction) addVary(name string) {
+	h := t.Header()
+	for _, v := range h.Values("Vary") {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "*" || strings.EqualFold(s, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// mediaType returns the lower-case media type of a Content-Type value, without parameters
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i != -1 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// acceptRange is a media range of an Accept header, e.g. "text/*;q=0.5"
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses the media ranges of Accept header values. Ranges with invalid syntax
+// are ignored.
+func parseAccept(values []string) []acceptRange {
+	var ranges []acceptRange
+	for _, value := range values {
+		for _, s := range strings.Split(value, ",") {
+			params := strings.Split(s, ";")
+			typ, subtype := splitMediaType(mediaType(params[0]))
+			if typ == "" || (typ == "*" && subtype != "*") {
+				continue
+			}
+			r := acceptRange{typ: typ, subtype: subtype, q: 1}
+			for _, p := range params[1:] {
+				p = strings.TrimSpace(p)
+				if len(p) > 2 && (p[0] == 'q' || p[0] == 'Q') && p[1] == '=' {
+					if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q >= 0 && q <= 1 {
+						r.q = q
+					}
+				}
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+func splitMediaType(mt string) (typ, subtype string) {
+	i := strings.IndexByte(mt, '/')
+	if i < 1 || i == len(mt)-1 {
+		return "", ""
+	}
+	return mt[:i], mt[i+1:]
+}
+
+// negotiate returns the quality and index of the offer most preferred by the Accept header
+// values accept, or -1 if no offer is acceptable. Each offer gets the quality of the most
+// specific media range which matches it.
+func negotiate(accept []string, offers []string) (q float64, index int) {
+	index = -1
+	ranges := parseAccept(accept)
+	if len(ranges) == 0 {
+		// missing or invalid Accept header
+		if len(offers) == 0 {
+			return 0, -1
+		}
+		return 1, 0
+	}
+	for i, offer := range offers {
+		typ, subtype := splitMediaType(strings.ToLower(offer))
+		oq, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := 0
+			switch {
+			case r.typ == typ && r.subtype == subtype:
+				s = 2
+			case r.typ == typ && r.subtype == "*":
+				s = 1
+			case r.typ == "*":
+				s = 0
+			default:
+				continue
+			}
+			if s > specificity {
+				oq, specificity = r.q, s
+			}
+		}
+		if oq > q {
+			q, index = oq, i
+		}
+	}
+	return q, index
+}
@@ -0,0 +1,89 @@
+package httpd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+type csvTable [][]string
+
+func (v csvTable) MarshalCSV() ([][]string, error) { return v, nil }
+
+func TestTransactionRespond(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	tpl, err := ParseHtmlTemplate("test", "<b>{{.}}</b>")
+	assert.NoErr("template", err)
+	s.HandleFunc("/str", func(t *Transaction) { t.Respond("hi", HTMLTemplateEncoder(tpl)) })
+	s.HandleFunc("/map", func(t *Transaction) { t.Respond(map[string]int{"a": 1}) })
+	s.HandleFunc("/csv", func(t *Transaction) { t.Respond(csvTable{{"a", "b"}, {"1", "2"}}) })
+
+	for _, c := range []struct {
+		path, accept string
+		status       int
+		ctype, body  string
+	}{
+		{"/str", "", 200, "text/html; charset=utf-8", "<b>hi</b>"},
+		{"/str", "text/plain", 200, "text/plain; charset=utf-8", "hi"},
+		{"/str", "text/html;q=0.5, application/json", 200, "application/json; charset=utf-8",
+			"\"hi\"\n"},
+		{"/str", "text/*;q=0.2, */*;q=0.1", 200, "text/html; charset=utf-8", "<b>hi</b>"},
+		{"/str", "text/*, text/html;q=0", 200, "text/plain; charset=utf-8", "hi"},
+		{"/str", "invalid", 200, "text/html; charset=utf-8", "<b>hi</b>"},
+		{"/map", "text/plain", 406, "", ""},
+		{"/map", "text/plain, */*;q=0.1", 200, "application/json; charset=utf-8", "{\"a\":1}\n"},
+		{"/csv", "text/csv", 200, "text/csv; charset=utf-8", "a,b\n1,2\n"},
+	} {
+		name := c.path + " " + c.accept
+		w := serve(s, "GET", c.path, nil, "Accept", c.accept)
+		assert.Eq(name+" status", w.Code, c.status)
+		assert.Eq(name+" Vary", w.Header().Get("Vary"), "Accept")
+		if c.status == 200 {
+			assert.Eq(name+" Content-Type", w.Header().Get("Content-Type"), c.ctype)
+			assert.Eq(name+" body", w.Body.String(), c.body)
+		}
+	}
+}
+
+func TestTransactionNegotiate(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.HandleFunc("/", func(t *Transaction) {
+		t.WriteString(t.Negotiate("text/html", "application/json"))
+	})
+	for accept, expect := range map[string]string{
+		"": "text/html",
+		"application/json;q=0.9, text/html;q=0.8": "application/json",
+		"application/*":        "application/json",
+		"*/*, text/html;q=0.1": "application/json",
+		"image/png":            "",
+	} {
+		w := serve(s, "GET", "/", nil, "Accept", accept)
+		assert.Eq("Accept: "+accept, w.Body.String(), expect)
+	}
+}
+
+func TestErrNotAcceptable(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	logbuf := captureLog(s)
+	var err error
+	s.HandleErrorFunc("/", func(t *Transaction) error {
+		err = t.Respond(map[string]int{"a": 1})
+		return err
+	})
+	w := serve(s, "GET", "/", nil, "Accept", "image/png")
+	assert.Eq("status", w.Code, 406)
+	assert.Ok("ErrNotAcceptable", errors.Is(err, ErrNotAcceptable))
+	var herr *HTTPError
+	assert.Ok("ErrNotAcceptable is a HTTPError", errors.As(err, &herr) && herr.Status == 406)
+	assert.Ok("not logged as an error: "+logbuf.String(),
+		!strings.Contains(logbuf.String(), "[error]"))
+
+	err1 := err
+	serve(s, "GET", "/", nil, "Accept", "image/png")
+	assert.Ok("a new error is returned for each request", err != err1)
+}
@@ -0,0 +1,215 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// AttrKind is the kind of an AttrCond
+type AttrKind uint8
+
+const (
+	AttrContentType = AttrKind(iota) // e.g. "+json" in "POST+json /api/x"
+	AttrScheme                       // e.g. "https:" in "https: /secure/"
+	AttrHeader                       // e.g. "[X-Requested-With=XMLHttpRequest]"
+	AttrQuery                        // e.g. "?format=csv"
+)
+
+// AttrCond is a condition on an attribute of a request, other than its method, path and host.
+// Attribute conditions are written before the path of a route pattern, separated by
+// whitespace, in any of these forms:
+//
+//   GET|POST+json   method conditions with a content type; the request's Content-Type must
+//                   be one of ContentTypes["json"]. The methods may be omitted, e.g. "+json".
+//   https:          the request's scheme must be "https" (or "http" for "http:")
+//   [Name=value]    the request header Name must have the value "value"
+//   [Name]          the request must have the header Name
+//   ?name=value     the query parameter name must have the value "value"
+//   ?name           the request must have the query parameter name
+//
+// A route only matches requests which satisfy all of its attribute conditions.
+type AttrCond struct {
+	Kind     AttrKind
+	Name     string   // header or query parameter name, content type name or scheme
+	Value    string   // header or query parameter value (empty when HasValue is false)
+	HasValue bool     // false for conditions on the presence of a header or query parameter
+	types    []string // for AttrContentType
+}
+
+// ContentTypes are the content type names which can be used in route conditions, e.g. "json"
+// in "POST+json /api/x". An entry starting with "+" matches a structured syntax suffix, e.g.
+// "+json" matches "application/ld+json". A route copies the media types of a name when its
+// pattern is parsed; parsing a pattern which names an unknown content type fails.
+// Example: route.ContentTypes["csv"] = []string{"text/csv"} enables "POST+csv /import".
+var ContentTypes = map[string][]string{
+	"json":      {"application/json", "+json"},
+	"xml":       {"application/xml", "text/xml", "+xml"},
+	"form":      {"application/x-www-form-urlencoded"},
+	"multipart": {"multipart/form-data"},
+	"text":      {"text/plain"},
+}
+
+func (c *AttrCond) String() string {
+	switch c.Kind {
+	case AttrContentType:
+		return "+" + c.Name
+	case AttrScheme:
+		return c.Name + ":"
+	case AttrHeader:
+		if c.HasValue {
+			return "[" + c.Name + "=" + c.Value + "]"
+		}
+		return "[" + c.Name + "]"
+	}
+	if c.HasValue {
+		return "?" + c.Name + "=" + c.Value
+	}
+	return "?" + c.Name
+}
+
+// Match returns true if req satisfies the condition
+func (c *AttrCond) Match(req *http.Request) bool {
+	switch c.Kind {
+	case AttrContentType:
+		return matchContentType(c.types, req.Header.Get("Content-Type"))
+	case AttrScheme:
+		if req.TLS != nil {
+			return c.Name == "https"
+		}
+		return c.Name == "http"
+	case AttrHeader:
+		values, ok := req.Header[c.Name]
+		return ok && (!c.HasValue || (len(values) > 0 && values[0] == c.Value))
+	}
+	values, ok := req.URL.Query()[c.Name]
+	return ok && (!c.HasValue || (len(values) > 0 && values[0] == c.Value))
+}
+
+func matchContentType(types []string, contentType string) bool {
+	if i := strings.IndexByte(contentType, ';'); i != -1 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, t := range types {
+		if t[0] == '+' {
+			if strings.HasSuffix(contentType, t) {
+				return true
+			}
+		} else if contentType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAttrCond parses a condition term which is not a list of methods
+func parseAttrCond(term string) (*AttrCond, error) {
+	switch {
+	case term == "http:" || term == "https:":
+		return &AttrCond{Kind: AttrScheme, Name: term[:len(term)-1]}, nil
+	case term[0] == '+':
+		types := ContentTypes[term[1:]]
+		if len(types) == 0 {
+			return nil, fmt.Errorf("unknown content type %q in condition", term[1:])
+		}
+		return &AttrCond{Kind: AttrContentType, Name: term[1:], types: types}, nil
+	case term[0] == '[' && term[len(term)-1] == ']':
+		c := &AttrCond{Kind: AttrHeader}
+		c.Name, c.Value, c.HasValue = splitAttrPredicate(term[1 : len(term)-1])
+		if !isHeaderName(c.Name) {
+			return nil, fmt.Errorf("invalid header name %q in condition %q", c.Name, term)
+		}
+		c.Name = textproto.CanonicalMIMEHeaderKey(c.Name)
+		return c, nil
+	case term[0] == '?':
+		c := &AttrCond{Kind: AttrQuery}
+		c.Name, c.Value, c.HasValue = splitAttrPredicate(term[1:])
+		if len(c.Name) == 0 {
+			return nil, fmt.Errorf("missing query parameter name in condition %q", term)
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("invalid condition %q", term)
+}
+
+func splitAttrPredicate(s string) (name, value string, hasValue bool) {
+	if i := strings.IndexByte(s, '='); i != -1 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+func isHeaderName(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// isCondTerm returns true if term looks like a condition, i.e. it is not a host
+func isCondTerm(term string) bool {
+	if len(term) == 0 {
+		return false
+	}
+	if i := strings.IndexByte(term, '+'); i != -1 {
+		return (i == 0 || isCondList(strings.Split(term[:i], "|"))) && isVarNameWord(term[i+1:])
+	}
+	switch term[0] {
+	case '[':
+		// not an IPv6 address, e.g. "[::1]"
+		name, _, _ := splitAttrPredicate(strings.Trim(term, "[]"))
+		return term[len(term)-1] == ']' && isHeaderName(name)
+	case '?':
+		return true
+	}
+	return term == "http:" || term == "https:" || isCondList(strings.Split(term, "|"))
+}
+
+func isVarNameWord(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isVarNameByte(s[i]) {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// matchAttrs returns true if req satisfies all attribute conditions of r
+func (r *Route) matchAttrs(req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+	for _, c := range r.Attrs {
+		if !c.Match(req) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasAttrs returns true if r has all attribute conditions of r2
+func (r *Route) hasAttrs(r2 *Route) bool {
+	for _, c2 := range r2.Attrs {
+		found := false
+		for _, c := range r.Attrs {
+			if c.Kind == c2.Kind && c.Name == c2.Name && c.HasValue == c2.HasValue &&
+				c.Value == c2.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package route
 import (
 	"fmt"
 	"strings"
+	"sync"
 )
 
 type CondFlags uint64
@@ -19,45 +20,112 @@ const (
 	CondMethodTRACE
 )
 
-func (fl CondFlags) String() string {
-	if fl == 0 {
-		return "*"
-	}
-	var sb strings.Builder
-	if (fl & CondMethodGET) != 0 {
-		sb.WriteString("|GET")
-	}
-	if (fl & CondMethodCONNECT) != 0 {
-		sb.WriteString("|CONNECT")
+var (
+	condMethodMu sync.RWMutex // guards condMethodNames and condMethodFlags
+
+	// condMethodNames maps CondMethod bits to method names, in bit order.
+	// Methods added with RegisterMethod are appended.
+	condMethodNames = []string{
+		"GET", "CONNECT", "DELETE", "HEAD", "OPTIONS", "PATCH", "POST", "PUT", "TRACE",
 	}
-	if (fl & CondMethodDELETE) != 0 {
-		sb.WriteString("|DELETE")
+	condMethodFlags = map[string]CondFlags{} // registered methods
+)
+
+// RegisterMethod makes a HTTP method, e.g. "PROPFIND", available as a condition and returns
+// its flag. Registering a method which is already known returns its existing flag.
+// Method names must start with an uppercase letter followed by uppercase letters, digits,
+// "-" or "_". Up to 64 methods, including the standard ones, can be used.
+//
+// A method must be registered before it's used in a route pattern, e.g.
+//
+//   route.RegisterMethod("PROPFIND")
+//   r.Add("PROPFIND /dav/{path...}", handler)
+//
+func RegisterMethod(method string) (CondFlags, error) {
+	if f := MethodCond(method); f != 0 {
+		return f, nil
 	}
-	if (fl & CondMethodHEAD) != 0 {
-		sb.WriteString("|HEAD")
+	if !isMethodName(method) {
+		return 0, fmt.Errorf("invalid condition %q", method)
 	}
-	if (fl & CondMethodOPTIONS) != 0 {
-		sb.WriteString("|OPTIONS")
+	condMethodMu.Lock()
+	defer condMethodMu.Unlock()
+	if f := condMethodFlags[method]; f != 0 {
+		return f, nil // registered while we were waiting for the lock
 	}
-	if (fl & CondMethodPATCH) != 0 {
-		sb.WriteString("|PATCH")
+	if len(condMethodNames) == 64 {
+		return 0, fmt.Errorf("can not register method %q; too many methods", method)
 	}
-	if (fl & CondMethodPOST) != 0 {
-		sb.WriteString("|POST")
+	f := CondFlags(1) << uint(len(condMethodNames))
+	condMethodNames = append(condMethodNames, method)
+	condMethodFlags[method] = f
+	return f, nil
+}
+
+// MethodCond returns the condition flag for a HTTP method, or 0 if the method is unknown,
+// i.e. it is neither a standard method nor registered with RegisterMethod.
+func MethodCond(method string) CondFlags {
+	switch method {
+	case "GET":
+		return CondMethodGET
+	case "CONNECT":
+		return CondMethodCONNECT
+	case "DELETE":
+		return CondMethodDELETE
+	case "HEAD":
+		return CondMethodHEAD
+	case "OPTIONS":
+		return CondMethodOPTIONS
+	case "PATCH":
+		return CondMethodPATCH
+	case "POST":
+		return CondMethodPOST
+	case "PUT":
+		return CondMethodPUT
+	case "TRACE":
+		return CondMethodTRACE
 	}
-	if (fl & CondMethodPUT) != 0 {
-		sb.WriteString("|PUT")
+	condMethodMu.RLock()
+	defer condMethodMu.RUnlock()
+	return condMethodFlags[method]
+}
+
+func isMethodName(s string) bool {
+	if len(s) == 0 || s[0] < 'A' || s[0] > 'Z' {
+		return false
 	}
-	if (fl & CondMethodTRACE) != 0 {
-		sb.WriteString("|TRACE")
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if !(('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') || c == '-' || c == '_') {
+			return false
+		}
 	}
-	b := sb.String()
-	if len(b) == 0 {
+	return true
+}
+
+func (fl CondFlags) String() string {
+	methods := fl.Methods()
+	if len(methods) == 0 {
 		return "*"
 	}
-	return b[1:]
+	return strings.Join(methods, "|")
+}
+
+// Methods returns the names of the HTTP methods in fl, e.g. ["GET", "POST"]
+func (fl CondFlags) Methods() []string {
+	var methods []string
+	condMethodMu.RLock()
+	defer condMethodMu.RUnlock()
+	for i, name := range condMethodNames {
+		if (fl & (1 << uint(i))) != 0 {
+			methods = append(methods, name)
+		}
+	}
+	return methods
 }
 
+// ParseCondFlags parses a list of conditions, e.g. ["GET", "POST"].
+// Methods other than the standard ones must be registered with RegisterMethod first.
 func ParseCondFlags(tokens []string) (CondFlags, error) {
 	var f CondFlags
 	if len(tokens) == 1 && tokens[0] == "*" {
@@ -65,28 +133,27 @@ func ParseCondFlags(tokens []string) (CondFlags, error) {
 		return f, nil
 	}
 	for _, tok := range tokens {
-		switch tok {
-		case "GET":
-			f |= CondMethodGET
-		case "CONNECT":
-			f |= CondMethodCONNECT
-		case "DELETE":
-			f |= CondMethodDELETE
-		case "HEAD":
-			f |= CondMethodHEAD
-		case "OPTIONS":
-			f |= CondMethodOPTIONS
-		case "PATCH":
-			f |= CondMethodPATCH
-		case "POST":
-			f |= CondMethodPOST
-		case "PUT":
-			f |= CondMethodPUT
-		case "TRACE":
-			f |= CondMethodTRACE
-		default:
+		f1 := MethodCond(tok)
+		if f1 == 0 {
+			if isMethodName(tok) {
+				return f, fmt.Errorf("unknown method %q; see RegisterMethod", tok)
+			}
 			return f, fmt.Errorf("invalid condition %q", tok)
 		}
+		f |= f1
 	}
 	return f, nil
 }
+
+// isCondList returns true if tokens can be parsed by ParseCondFlags
+func isCondList(tokens []string) bool {
+	if len(tokens) == 1 && tokens[0] == "*" {
+		return true
+	}
+	for _, tok := range tokens {
+		if !isMethodName(tok) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// RouteInfo describes a route. See Router.RouteTable
+type RouteInfo struct {
+	Pattern    string      `json:"pattern"`              // pattern the route was added with
+	Conditions []string    `json:"conditions,omitempty"` // e.g. ["GET", "HEAD", "+json"]
+	Host       string      `json:"host,omitempty"`       // host pattern
+	Vars       []string    `json:"vars,omitempty"`       // var names, in order of appearance
+	Name       string      `json:"name,omitempty"`
+	Handler    string      `json:"handler"`         // type or function name of the handler
+	Mount      []RouteInfo `json:"mount,omitempty"` // routes of a mounted router
+}
+
+// RouteTable describes the routes of r, in order of priority.
+// Routes of mounted routers are included in the Mount field of their mount points.
+func (r *Router) RouteTable() []RouteInfo {
+	table := make([]RouteInfo, len(r.Routes))
+	for i, route := range r.Routes {
+		info := RouteInfo{
+			Pattern:    route.Source,
+			Conditions: route.Conditions.Methods(),
+			Host:       route.Host,
+			Vars:       route.varNames(),
+			Name:       route.Name,
+			Handler:    handlerName(route.Handler),
+		}
+		for _, c := range route.Attrs {
+			info.Conditions = append(info.Conditions, c.String())
+		}
+		if route.Sub != nil {
+			info.Mount = route.Sub.RouteTable()
+		}
+		table[i] = info
+	}
+	return table
+}
+
+// varNames returns the names of the vars of r, ordered by position
+func (r *Route) varNames() []string {
+	if len(r.Vars) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(r.Vars))
+	for name := range r.Vars {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return r.Vars[names[i]] < r.Vars[names[j]] })
+	return names
+}
+
+// handlerName returns the name of a function handler, or the type of any other handler.
+// A handler with an Unwrap method, like httpd.Route, is named by what Unwrap returns.
+func handlerName(h interface{}) string {
+	if w, ok := h.(interface{ Unwrap() interface{} }); ok {
+		h = w.Unwrap()
+	}
+	if h == nil {
+		return ""
+	}
+	if v := reflect.ValueOf(h); v.Kind() == reflect.Func {
+		if f := runtime.FuncForPC(v.Pointer()); f != nil {
+			return f.Name()
+		}
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// Explanation describes how a route was tried when matching a request. See Router.Explain
+type Explanation struct {
+	Route   *Route        `json:"-"`
+	Pattern string        `json:"pattern"`
+	Matched bool          `json:"matched"`
+	Reason  string        `json:"reason,omitempty"` // why the route was rejected
+	Mount   []Explanation `json:"mount,omitempty"`  // routes of a mounted router which were tried
+}
+
+// Explain reports how a request would be matched. Each route that would be tried is
+// described, in order, along with why it was rejected. The last explanation is that of the
+// matching route, unless no route matches. Routes with attribute conditions are tried as if
+// the request had no headers; use ExplainRequest to explain the matching of a request.
+// path must be escaped if r.EscapedPath is true.
+func (r *Router) Explain(method, host, path string) []Explanation {
+	req := &http.Request{
+		Method: method,
+		Host:   host,
+		URL:    &url.URL{Path: path},
+		Header: http.Header{},
+	}
+	if r.EscapedPath {
+		req.URL.RawPath = path
+		req.URL.Path, _ = url.PathUnescape(path)
+	}
+	return r.ExplainRequest(req)
+}
+
+// ExplainRequest is like Explain but uses the method, host, path and other attributes of req
+func (r *Router) ExplainRequest(req *http.Request) []Explanation {
+	path := r.URLPath(req.URL)
+	expl, ok := r.explain(req, path, false)
+	if ok && MethodCond(req.Method) == CondMethodHEAD && matchedRoute(expl).ImplicitHEAD {
+		// like match, prefer a route which explicitly lists HEAD
+		if expl2, ok := r.explain(req, path, true); ok {
+			return expl2
+		}
+	}
+	return expl
+}
+
+// matchedRoute returns the route which expl ends with, in a mounted router if it's a mount point
+func matchedRoute(expl []Explanation) *Route {
+	e := expl[len(expl)-1]
+	for len(e.Mount) > 0 {
+		e = e.Mount[len(e.Mount)-1]
+	}
+	return e.Route
+}
+
+// explain explains the matching of req. When explicitHEAD is true, only routes which
+// explicitly list HEAD in their conditions can match, like in the second lookup of match.
+func (r *Router) explain(req *http.Request, path string, explicitHEAD bool) ([]Explanation, bool) {
+	if len(r.BasePath) > 0 {
+		if !strings.HasPrefix(path, r.BasePath) {
+			return nil, false
+		}
+		path = path[len(r.BasePath):]
+	}
+	conditions := MethodCond(req.Method)
+	var expl []Explanation
+	for _, route := range r.Routes {
+		e := Explanation{Route: route, Pattern: route.Source}
+		rest, ok := route.matchPath(path)
+		if !ok {
+			e.Reason = "path does not match"
+		} else if route.Conditions != 0 && (route.Conditions&conditions) == 0 {
+			e.Reason = fmt.Sprintf("method %s is not one of %s", req.Method, route.Conditions)
+		} else if !route.matchHost(req.Host) {
+			e.Reason = fmt.Sprintf("host %q does not match %q", req.Host, route.Host)
+		} else if c := route.failedAttr(req); c != nil {
+			e.Reason = fmt.Sprintf("condition %s is not satisfied", c)
+		} else if explicitHEAD && route.Sub == nil &&
+			(route.ImplicitHEAD || route.Conditions == 0) {
+			e.Reason = "a route which lists HEAD explicitly is preferred"
+		} else if route.Sub != nil {
+			e.Mount, e.Matched = route.Sub.explain(req, rest, explicitHEAD)
+			if !e.Matched {
+				e.Reason = "no route of the mounted router matches"
+			}
+		} else {
+			e.Matched = true
+		}
+		expl = append(expl, e)
+		if e.Matched {
+			return expl, true
+		}
+	}
+	return expl, false
+}
+
+// matchPath matches path against r's path pattern. For a prefix route, rest is the remainder
+// of path, starting with "/".
+func (r *Route) matchPath(path string) (rest string, ok bool) {
+	if r.Pattern == nil {
+		if !r.IsPrefix {
+			return "", path == r.EntryPrefix
+		}
+		if !strings.HasPrefix(path, r.EntryPrefix) {
+			return "", false
+		}
+		return path[len(r.EntryPrefix)-1:], true
+	}
+	loc := r.Pattern.FindStringSubmatchIndex(path)
+	if len(loc) != 2+2*r.nvars {
+		return "", false
+	}
+	if r.IsPrefix {
+		rest = path[loc[1]-1:]
+	}
+	return rest, true
+}
+
+func (r *Route) matchHost(host string) bool {
+	if r.host == nil {
+		return true
+	}
+	_, ok := r.host.match(host)
+	return ok
+}
+
+// failedAttr returns the first attribute condition of r which req does not satisfy
+func (r *Route) failedAttr(req *http.Request) *AttrCond {
+	for _, c := range r.Attrs {
+		if !c.Match(req) {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package route
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const defaultHostVarPattern = `[^.]+` // implicit pattern in "{name}" of a host (one label)
+
+// hostPattern matches the host of a request, e.g. "example.com", "*.example.com" or
+// "{tenant}.example.com". Matching is case-insensitive.
+type hostPattern struct {
+	literal string         // set when the pattern has no wildcards or vars
+	re      *regexp.Regexp // set when the pattern has wildcards or vars
+	nvars   int            // number of vars, including "_" placeholders
+}
+
+func (h *hostPattern) match(host string) (values []string, ok bool) {
+	if h.re == nil {
+		return nil, strings.EqualFold(h.literal, host)
+	}
+	values = h.re.FindStringSubmatch(host)
+	if len(values) != 1+h.nvars {
+		return nil, false
+	}
+	return values[1:], true
+}
+
+// parseHost parses the host part of a route pattern and sets r.Host and r.host.
+// Vars in the host are added to r.Vars.
+func (r *Route) parseHost(pattern string) error {
+	r.Host = pattern
+	r.host = nil
+	if len(pattern) == 0 {
+		return nil
+	}
+	r.host = &hostPattern{}
+	locations, err := findVars(pattern)
+	if err != nil {
+		return err
+	}
+	if len(locations) == 0 && strings.IndexByte(pattern, '*') == -1 {
+		r.host.literal = pattern
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+	// quoteLiteral escapes a literal chunk, turning "*" into a wildcard for one label
+	quoteLiteral := func(s string) {
+		sb.WriteString(strings.Replace(regexp.QuoteMeta(s), `\*`, defaultHostVarPattern, -1))
+	}
+	plainStart := 0
+	for _, loc := range locations {
+		quoteLiteral(pattern[plainStart:loc[0]])
+		plainStart = loc[1]
+
+		varName := pattern[loc[2]:loc[3]]
+		if loc[6] == 1 {
+			return fmt.Errorf("rest var %q not allowed in route host %q", varName, pattern)
+		}
+		pat := defaultHostVarPattern
+		var vt *VarType
+		if loc[4] > -1 {
+			pat, vt, err = varPattern(pattern, varName, pattern[loc[4]:loc[5]], pat)
+			if err != nil {
+				return err
+			}
+		}
+		r.setVarType(varName, vt)
+		if varName != "_" {
+			if _, ok := r.Vars[varName]; ok {
+				return fmt.Errorf("duplicate var %q in route host %q", varName, pattern)
+			}
+			if r.Vars == nil {
+				r.Vars = make(map[string]int, len(locations))
+			}
+			r.Vars[varName] = r.host.nvars
+		}
+		r.host.nvars++
+		sb.WriteByte('(')
+		sb.WriteString(pat)
+		sb.WriteByte(')')
+	}
+	quoteLiteral(pattern[plainStart:])
+	sb.WriteByte('$')
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return err
+	}
+	r.host.re = re
+	return nil
+}
+
+// indexPathStart returns the index of the first "/" in a route pattern which is not part
+// of a var, a header condition or a query condition, or -1 if there is no such "/".
+func indexPathStart(pattern string) (int, error) {
+	locations, err := findVars(pattern)
+	if err != nil {
+		return -1, err
+	}
+	start := 0
+	// skip header conditions like "[Accept=text/html]" and query conditions like "?next=/a"
+	for i := 0; i < len(pattern); i++ {
+		if c := pattern[i]; c == '/' {
+			break
+		} else if c == '[' && (i == 0 || isSpace(pattern[i-1])) {
+			if end := strings.IndexByte(pattern[i:], ']'); end != -1 {
+				start = i + end + 1
+				i = start - 1
+			}
+		} else if c == '?' && (i == 0 || isSpace(pattern[i-1])) {
+			start = len(pattern)
+			if end := strings.IndexAny(pattern[i:], " \t\r\n"); end != -1 {
+				start = i + end
+			}
+			i = start - 1
+		}
+	}
+	for len(locations) > 0 && locations[0][0] < start {
+		locations = locations[1:]
+	}
+	for _, loc := range locations {
+		if i := strings.IndexByte(pattern[start:loc[0]], '/'); i != -1 {
+			return start + i, nil
+		}
+		start = loc[1]
+	}
+	if i := strings.IndexByte(pattern[start:], '/'); i != -1 {
+		return start + i, nil
+	}
+	return -1, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
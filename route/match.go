@@ -3,30 +3,66 @@ package route
 type Match struct {
 	*Route
 	Path   string   // relative to router's BasePath
+	Parent *Match   // match of the mount point, when Route belongs to a mounted router
 	values []string // variable values
+	absent []bool   // values of vars in optional groups which did not match (nil if none)
 }
 
-// Values returns all variable values
+// Values returns all variable values. Values of absent vars are empty.
 func (m Match) Values() []string { return m.values }
 
-// Vars returns all variable names and values as a map
+// Vars returns all variable names and values as a map, including vars of any parent matches.
+// Absent vars, i.e. vars of optional groups which did not match, are not included.
 func (m Match) Vars() map[string]string {
-	kv := make(map[string]string, len(m.Route.Vars))
+	var kv map[string]string
+	if m.Parent != nil {
+		kv = m.Parent.Vars()
+	} else {
+		kv = make(map[string]string, len(m.Route.Vars))
+	}
 	if len(m.Route.Vars) > 0 {
 		for name, index := range m.Route.Vars {
-			kv[name] = m.values[index]
+			if m.absent == nil || !m.absent[index] {
+				kv[name] = m.values[index]
+			}
 		}
 	}
 	return kv
 }
 
-// Var retrieves the value of a variable by name
+// Var retrieves the value of a variable by name.
+// Vars of parent matches are searched when the route does not have a var with the name.
+// fallback[0], or "" if no fallback is given, is returned when there is no such var or when
+// the var is absent since it is part of an optional group which did not match, e.g. "n" in
+// "/blog{/page/{n}}?" for the path "/blog".
 func (m Match) Var(name string, fallback ...string) string {
 	if i, ok := m.Route.Vars[name]; ok && m.values != nil {
-		return m.values[i]
+		if m.absent == nil || !m.absent[i] {
+			return m.values[i]
+		}
+	} else if m.Parent != nil {
+		return m.Parent.Var(name, fallback...)
 	}
 	if len(fallback) > 0 {
 		return fallback[0]
 	}
 	return ""
 }
+
+// Value retrieves the value of a variable by name, converted according to its type.
+// For example, the value of "id" in "/user/{id:int}" is an int.
+// The value of an untyped variable is a string. The value of an absent var is nil.
+func (m Match) Value(name string) (interface{}, error) {
+	i, ok := m.Route.Vars[name]
+	if !ok && m.Parent != nil {
+		return m.Parent.Value(name)
+	}
+	if ok && m.absent != nil && m.absent[i] {
+		return nil, nil
+	}
+	s := m.Var(name)
+	if vt := m.Route.VarType(name); vt != nil {
+		return vt.Parse(s)
+	}
+	return s, nil
+}
@@ -0,0 +1,59 @@
+package route
+
+import "sort"
+
+// groupDelim is the start "{" or end "}?" of an optional group in a path pattern,
+// e.g. "{/page/{n}}?"
+type groupDelim struct {
+	pos  int  // position in the pattern
+	open bool // true for "{", false for "}?"
+}
+
+// findOptionalGroups finds the delimiters of optional groups in a path pattern, in order.
+// locations are the vars of pattern, as returned by findVars. A "{" which does not start a
+// var starts a group if there is a matching "}?"; otherwise it is just a literal "{".
+func findOptionalGroups(pattern string, locations [][]int) []groupDelim {
+	var delims []groupDelim
+	var opens []int // positions of "{" of groups not yet closed
+	for i := 0; i < len(pattern); i++ {
+		if len(locations) > 0 && i == locations[0][0] {
+			i = locations[0][1] - 1 // skip var
+			locations = locations[1:]
+			continue
+		}
+		switch pattern[i] {
+		case '{':
+			opens = append(opens, i)
+		case '}':
+			if len(opens) > 0 && i+1 < len(pattern) && pattern[i+1] == '?' {
+				delims = append(delims,
+					groupDelim{pos: opens[len(opens)-1], open: true},
+					groupDelim{pos: i})
+				opens = opens[:len(opens)-1]
+				i++
+			}
+		}
+	}
+	sort.Slice(delims, func(i, j int) bool { return delims[i].pos < delims[j].pos })
+	return delims
+}
+
+// isOptionalGroupsEnd returns true if s consists of one or more ends of optional groups
+func isOptionalGroupsEnd(s string) bool {
+	for len(s) >= 2 && s[:2] == "}?" {
+		s = s[2:]
+	}
+	return len(s) == 0
+}
+
+// groupEnd returns the index of the end of the optional group which starts at parts[start]
+func groupEnd(parts []patternPart, start int) int {
+	depth := 0
+	for i := start; i < len(parts); i++ {
+		depth += int(parts[i].group)
+		if depth == 0 {
+			return i
+		}
+	}
+	return len(parts) - 1
+}
@@ -6,20 +6,38 @@ import (
 	"strings"
 )
 
-var (
-	reSplitOR   = regexp.MustCompile(`\s*\|\s*`)
-	reMatchVars = regexp.MustCompile(`\{(?P<key>\w+)(?:\:\s*(?P<value>[^\}]*)|)\s*\}`)
-)
+var reSplitOR = regexp.MustCompile(`\s*\|\s*`)
 
 const defaultVarPattern = `[^/]+` // implicit pattern in "{name}" (no ":pattern")
+const restVarPattern = `(?s:.*)`  // pattern of "{name...}" (rest of the path)
 
 type Route struct {
+	Source      string // the pattern as given to Parse, e.g. "GET /user/{id:int}"
 	Conditions  CondFlags
+	Attrs       []*AttrCond // request attribute conditions, e.g. "+json" in "POST+json /x"
 	Pattern     *regexp.Regexp
 	Vars        map[string]int // name => match position
 	EntryPrefix string
 	IsPrefix    bool
 	Handler     interface{}
+	Host        string  // host pattern, e.g. "{tenant}.example.com" (empty for any host)
+	Sub         *Router // when non-nil, this is a mount point for Sub (see Router.Mount)
+	Name        string  // optional name, used to build URLs with Router.URL
+
+	// ImplicitHEAD is true when CondMethodHEAD was added to Conditions because the pattern
+	// lists GET but not HEAD. A HEAD request is matched by a route which explicitly lists HEAD
+	// in preference to such a route, regardless of their order. See DisableImplicitHEAD.
+	ImplicitHEAD bool
+
+	index    int                 // position in Router.Routes
+	id       uint64              // identifies the route and its copies made by Router.Clone
+	varTypes map[string]*VarType // types of typed vars
+	parts    []patternPart       // path pattern, split into literals and vars
+	host     *hostPattern        // nil when Host is empty
+	nvars    int                 // number of path vars, including "_" placeholders
+	segs     []segment           // leading path segments which the router's tree can match
+	partial  bool                // true when segs does not cover the whole pattern (use Pattern)
+	hasRest  bool                // true when the last var is a "{name...}" var
 }
 
 func (r *Route) String() string {
@@ -27,33 +45,116 @@ func (r *Route) String() string {
 	if r.Pattern != nil {
 		pattern = r.Pattern.String()
 	}
-	return fmt.Sprintf("{%s %s}", r.Conditions, pattern)
+	conds := r.Conditions.String()
+	for _, c := range r.Attrs {
+		conds += " " + c.String()
+	}
+	return fmt.Sprintf("{%s %s%s}", conds, r.Host, pattern)
+}
+
+// DisableImplicitHEAD stops a GET route from matching HEAD requests.
+// It has no effect on routes which explicitly list HEAD in their conditions.
+func (r *Route) DisableImplicitHEAD() {
+	if r.ImplicitHEAD {
+		r.Conditions &^= CondMethodHEAD
+		r.ImplicitHEAD = false
+	}
+}
+
+// parseConditions parses the conditions of a route pattern, e.g. "GET|POST+json ?debug",
+// sets r.Attrs and returns the method conditions
+func (r *Route) parseConditions(condstr string) (CondFlags, error) {
+	var conds CondFlags
+	r.Attrs = nil
+	// "GET | POST" -> "GET|POST"
+	for _, term := range strings.Fields(reSplitOR.ReplaceAllString(condstr, "|")) {
+		term = strings.Trim(term, "|")
+		if len(term) == 0 || term == "*" {
+			continue
+		}
+		methods := term
+		if term[0] == '[' || term[0] == '?' || term == "http:" || term == "https:" {
+			methods = ""
+		} else if i := strings.IndexByte(term, '+'); i != -1 {
+			methods, term = term[:i], term[i:]
+		} else {
+			term = ""
+		}
+		if len(methods) > 0 {
+			f, err := ParseCondFlags(strings.Split(methods, "|"))
+			if err != nil {
+				return 0, err
+			}
+			conds |= f
+		}
+		if len(term) > 0 {
+			c, err := parseAttrCond(term)
+			if err != nil {
+				return 0, err
+			}
+			r.Attrs = append(r.Attrs, c)
+		}
+	}
+	return conds, nil
+}
+
+func (r *Route) setVarType(name string, vt *VarType) {
+	if vt != nil && name != "_" {
+		if r.varTypes == nil {
+			r.varTypes = make(map[string]*VarType)
+		}
+		r.varTypes[name] = vt
+	}
+}
+
+// VarType returns the type of a var, or nil if the var is untyped or does not exist
+func (r *Route) VarType(name string) *VarType {
+	return r.varTypes[name]
 }
 
 func (r *Route) Parse(pathPattern string) error {
-	// parse: "COND|COND /path/pattern" -> {{"COND", "COND"}, "path/pattern"}
+	// parse: "COND|COND host/path/pattern" -> {{"COND", "COND"}, "host", "path/pattern"}
 	pathPattern = strings.TrimSpace(pathPattern)
-	i := strings.IndexByte(pathPattern, '/')
+	r.Source = pathPattern
+	i, err := indexPathStart(pathPattern)
+	if err != nil {
+		return err
+	}
 	if i == -1 {
 		return fmt.Errorf("invalid route pattern %q; missing leading \"/\" in path", pathPattern)
 	}
-	var conditions []string
-	condstr := strings.Trim(pathPattern[:i], "| \t\r\n")
-	if len(condstr) > 0 {
-		conditions = reSplitOR.Split(condstr, -1)
-		pathPattern = pathPattern[i:]
-	}
-	if len(pathPattern) == 0 {
-		return fmt.Errorf("empty route pattern")
+	condstr := pathPattern[:i]
+	pathPattern = pathPattern[i:]
+
+	// host is whatever directly precedes the path, unless it is a condition,
+	// e.g. "example.com" in "GET example.com/foo" but not "GET|POST" in "GET|POST/foo"
+	var hoststr string
+	if hi := strings.LastIndexAny(condstr, " \t\r\n") + 1; hi < len(condstr) {
+		if !isCondTerm(condstr[hi:]) {
+			hoststr = condstr[hi:]
+			condstr = condstr[:hi]
+		}
 	}
 
 	// parse conditions
-	conds, err := ParseCondFlags(conditions)
+	conds, err := r.parseConditions(condstr)
 	if err != nil {
 		return err
 	}
+	// GET routes also match HEAD requests, unless HEAD is explicitly listed
+	r.ImplicitHEAD = (conds&CondMethodGET) != 0 && (conds&CondMethodHEAD) == 0
+	if r.ImplicitHEAD {
+		conds |= CondMethodHEAD
+	}
 	r.Conditions = conds
 
+	// parse host
+	r.Vars = nil
+	r.varTypes = nil
+	if err := r.parseHost(hoststr); err != nil {
+		return err
+	}
+
 	// prefix? i.e. "/foo/" is a prefix while "/foo" and "/foo/!" are not.
 	c := pathPattern[len(pathPattern)-1]
 	if c == '/' {
@@ -65,65 +166,117 @@ func (r *Route) Parse(pathPattern string) error {
 	}
 
 	// find vars
-	pathPatternBytes := []byte(pathPattern)
-	locations := reMatchVars.FindAllSubmatchIndex(pathPatternBytes, -1)
-	if len(locations) == 0 {
+	locations, err := findVars(pathPattern)
+	if err != nil {
+		return err
+	}
+	r.hasRest = false
+	groups := findOptionalGroups(pathPattern, locations)
+	if len(locations) == 0 && len(groups) == 0 {
 		// no vars
 		r.EntryPrefix = pathPattern
-		r.Vars = nil
 		r.Pattern = nil
-		return nil
+		r.nvars = 0
+		r.parts = []patternPart{{literal: pathPattern}}
+		return r.parseSegments(r.parts)
 	}
 
-	// has vars; will build r.Pattern
+	// has vars or optional groups; will build r.Pattern
 	r.EntryPrefix = ""
-	r.Vars = make(map[string]int, len(locations))
-	resultPattern := make([]byte, 1, len(pathPatternBytes)*2)
+	if r.Vars == nil {
+		r.Vars = make(map[string]int, len(locations))
+	}
+	r.nvars = len(locations)
+	varOffset := 0 // host vars come before path vars
+	if r.host != nil {
+		varOffset = r.host.nvars
+	}
+	resultPattern := make([]byte, 1, len(pathPattern)*2)
 	resultPattern[0] = '^'
 	plainStart := 0
+	parts := make([]patternPart, 0, len(locations)*2+len(groups)+1)
+
+	// addPlain adds a plain chunk pathPattern[start:end] to resultPattern and parts, turning
+	// the delimiters of optional groups into "(?:" and ")?"
+	addPlain := func(start, end int) {
+		for start < end {
+			chunkEnd := end
+			if len(groups) > 0 && groups[0].pos < end {
+				chunkEnd = groups[0].pos
+			}
+			if start < chunkEnd {
+				chunk := pathPattern[start:chunkEnd]
+				if start == 0 {
+					r.EntryPrefix = chunk
+				}
+				resultPattern = append(resultPattern, regexp.QuoteMeta(chunk)...)
+				parts = append(parts, patternPart{literal: chunk})
+			}
+			if chunkEnd == end {
+				break
+			}
+			g := groups[0]
+			groups = groups[1:]
+			if g.open {
+				resultPattern = append(resultPattern, "(?:"...)
+				parts = append(parts, patternPart{group: 1})
+				start = g.pos + 1 // "{"
+			} else {
+				resultPattern = append(resultPattern, ")?"...)
+				parts = append(parts, patternPart{group: -1})
+				start = g.pos + 2 // "}?"
+			}
+		}
+	}
 
 	for varIndex, loc := range locations {
 		varStart, varEnd := loc[0], loc[1] // range of whole "{...}" chunk
 
-		// add plain chunk to resultPattern (whatever comes before the var)
-		if plainStart < varStart {
-			chunk := pathPattern[plainStart:varStart]
-			if plainStart == 0 {
-				r.EntryPrefix = chunk
-			}
-			resultPattern = append(resultPattern, regexp.QuoteMeta(chunk)...)
-		}
+		// add plain chunk (whatever comes before the var)
+		addPlain(plainStart, varStart)
 		plainStart = varEnd
 
 		// extract var name and pattern
 		varName := pathPattern[loc[2]:loc[3]]
 		pat := defaultVarPattern
-		if loc[4] > -1 {
-			// trim away leading "^" and trailing "$" in pattern. E.g. "^\w+$" -> "\w+"
-			pat = pathPattern[loc[4]:loc[5]]
-			if len(pat) == 0 {
-				pat = defaultVarPattern
+		var vt *VarType
+		if loc[6] == 1 {
+			if varEnd != len(pathPattern) && !isOptionalGroupsEnd(pathPattern[varEnd:]) {
+				return fmt.Errorf("rest var %q must be at the end of route pattern %q",
+					varName, pathPattern)
+			}
+			pat = restVarPattern
+			r.hasRest = true
+		} else if loc[4] > -1 {
+			pat, vt, err = varPattern(pathPattern, varName, pathPattern[loc[4]:loc[5]], pat)
+			if err != nil {
+				return err
 			}
 		}
+		r.setVarType(varName, vt)
 
 		// memorize var
 		if varName != "_" {
 			if _, ok := r.Vars[varName]; ok {
 				return fmt.Errorf("duplicate var %q in route pattern %q", varName, pathPattern)
 			}
-			r.Vars[varName] = varIndex
+			r.Vars[varName] = varOffset + varIndex
 		}
 
 		// add var capture pattern
 		resultPattern = append(resultPattern, '(')
 		resultPattern = append(resultPattern, pat...)
 		resultPattern = append(resultPattern, ')')
+		varRe, err := regexp.Compile("^(?:" + pat + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pattern for var %q in route pattern %q: %v",
+				varName, pathPattern, err)
+		}
+		parts = append(parts, patternPart{isVar: true, name: varName, pattern: pat, re: varRe})
 	}
 
 	// add any trailing plain chunk
-	if plainStart < len(pathPattern) {
-		resultPattern = append(resultPattern, regexp.QuoteMeta(pathPattern[plainStart:])...)
-	}
+	addPlain(plainStart, len(pathPattern))
 
 	// terminating "$", unless r.IsPrefix
 	if !r.IsPrefix {
@@ -136,5 +289,6 @@ func (r *Route) Parse(pathPattern string) error {
 		return err
 	}
 	r.Pattern = re
-	return nil
+	r.parts = parts
+	return r.parseSegments(parts)
 }
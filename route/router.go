@@ -2,14 +2,48 @@ package route
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync/atomic"
 )
 
+var routeIDs uint64 // source of Route.id
+
 type Router struct {
 	// BasePath is the URL path prefix where these routes begin.
 	// All rules within are relative to this path.
 	BasePath string
-	Routes   []Route
+
+	// OrderBySpecificity changes how routes are prioritized when more than one route matches
+	// a request. By default the route registered first wins. When OrderBySpecificity is true,
+	// the most specific route wins; static segments beat vars and longer patterns beat shorter
+	// ones. Set this before adding any routes.
+	OrderBySpecificity bool
+
+	// EscapedPath makes MatchRequest match against the escaped form of a request's path
+	// (url.URL.EscapedPath) rather than the decoded form. This makes it possible to tell an
+	// encoded "/" ("%2F") in a var apart from a path separator. Var values are decoded
+	// individually, e.g. "a/b" for "id" in "/doc/{id}" and the path "/doc/a%2Fb".
+	// Literal parts of patterns, as well as paths given to Match and MatchHost, must be escaped.
+	EscapedPath bool
+
+	// Routes in order of priority. Use Add to add routes.
+	Routes []*Route
+
+	tree *node // segment tree of Routes
+}
+
+// ConflictError is returned by Router.Add when the added route can never match since a route
+// with higher priority matches all of its requests. The route is added regardless.
+type ConflictError struct {
+	Route      *Route // the unreachable route
+	ShadowedBy *Route // the route that matches all requests of Route
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("route %q is unreachable; shadowed by route %q",
+		e.Route.Source, e.ShadowedBy.Source)
 }
 
 func (r *Router) Add(pattern string, handler interface{}) (*Route, error) {
@@ -29,20 +63,165 @@ func (r *Router) Add(pattern string, handler interface{}) (*Route, error) {
 	}
 
 	// new Route
-	r.Routes = append(r.Routes, Route{})
-	route := &(r.Routes[len(r.Routes)-1])
-
-	// parse
+	route := &Route{Handler: handler, index: len(r.Routes), id: atomic.AddUint64(&routeIDs, 1)}
 	if err := route.Parse(pattern); err != nil {
-		r.Routes = r.Routes[:len(r.Routes)-1]
 		return nil, err
 	}
 
-	route.Handler = handler
+	if r.OrderBySpecificity {
+		// insert after all routes which are at least as specific
+		i := len(r.Routes)
+		for i > 0 && compareSpecificity(route, r.Routes[i-1]) > 0 {
+			i--
+		}
+		r.Routes = append(r.Routes, nil)
+		copy(r.Routes[i+1:], r.Routes[i:])
+		r.Routes[i] = route
+		r.rebuildTree()
+	} else {
+		r.Routes = append(r.Routes, route)
+		if r.tree == nil {
+			r.tree = newNode()
+		}
+		r.tree.insert(route)
+	}
+
+	// check if any route with higher priority shadows the new route
+	for _, r2 := range r.Routes[:route.index] {
+		if r2.covers(route) {
+			return route, &ConflictError{Route: route, ShadowedBy: r2}
+		}
+	}
+
 	return route, nil
 }
 
+// Remove removes a route which was added with Add, or a copy of it made by Clone.
+// Returns false if the route is not in r.
+// Remove must not be called while r is in use by other goroutines; instead, remove the route
+// from a clone of r and replace r with the clone.
+func (r *Router) Remove(route *Route) bool {
+	for i, r2 := range r.Routes {
+		if r2 == route || (r2.id == route.id && route.id != 0) {
+			r.Routes = append(r.Routes[:i:i], r.Routes[i+1:]...)
+			r.rebuildTree()
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a copy of r which can be changed without affecting r, for example to build
+// a new version of a route table while r is in use by other goroutines.
+// The routes of the copy are copies of the routes of r. Mounted routers are not copied.
+func (r *Router) Clone() *Router {
+	r2 := &Router{
+		BasePath:           r.BasePath,
+		OrderBySpecificity: r.OrderBySpecificity,
+		EscapedPath:        r.EscapedPath,
+	}
+	r2.Routes = make([]*Route, len(r.Routes))
+	for i, route := range r.Routes {
+		route2 := *route
+		r2.Routes[i] = &route2
+	}
+	r2.rebuildTree()
+	return r2
+}
+
+func (r *Router) rebuildTree() {
+	r.tree = newNode()
+	for i, route := range r.Routes {
+		route.index = i
+		r.tree.insert(route)
+	}
+}
+
+// Match finds the first route which matches conditions and path.
+// Routes with a host pattern never match; use MatchHost to match those.
+// Routes with attribute conditions never match; use MatchRequest to match those.
 func (r *Router) Match(conditions CondFlags, path string) (*Match, error) {
+	return r.MatchHost(conditions, "", path)
+}
+
+// MatchHost finds the first route which matches conditions, host and path.
+// host should not include a port number.
+func (r *Router) MatchHost(conditions CondFlags, host, path string) (*Match, error) {
+	return r.match(matchState{conditions: conditions, host: host}, path)
+}
+
+// MatchRequest finds the first route which matches req, including any attribute conditions
+// of the route. The host and path of req.Host and req.URL.Path are used as is; they should
+// be cleaned and req.Host should not include a port number.
+// Methods which are not registered (see RegisterMethod) only match unconditional routes.
+func (r *Router) MatchRequest(req *http.Request) (*Match, error) {
+	st := matchState{conditions: MethodCond(req.Method), req: req, host: req.Host}
+	return r.match(st, r.URLPath(req.URL))
+}
+
+// URLPath returns the path of u which r matches against; u.EscapedPath() when r.EscapedPath
+// is true and u.Path otherwise.
+func (r *Router) URLPath(u *url.URL) string {
+	if r.EscapedPath {
+		return u.EscapedPath()
+	}
+	return u.Path
+}
+
+func (r *Router) match(st matchState, path string) (*Match, error) {
+	st.escaped = r.EscapedPath
+	if !r.lookup(&st, path) {
+		// no route found
+		return nil, nil
+	}
+	m := st.makeMatch()
+	if m.ImplicitHEAD && st.conditions == CondMethodHEAD {
+		// a route which explicitly lists HEAD wins over a GET route, even if added later
+		st2 := matchState{conditions: st.conditions, req: st.req, host: st.host,
+			escaped: st.escaped, explicitHEAD: true}
+		if r.lookup(&st2, path) {
+			return st2.makeMatch(), nil
+		}
+	}
+	return m, nil
+}
+
+// Allowed returns the union of the conditions of all routes that match host and path,
+// regardless of what conditions those routes have. ok is false if no route matches.
+// If any of the matching routes are unconditional, conditions is 0 ("any").
+func (r *Router) Allowed(host, path string) (conditions CondFlags, ok bool) {
+	st := matchState{host: host, collect: true, escaped: r.EscapedPath}
+	if !r.lookup(&st, path) {
+		return 0, false
+	}
+	if st.allowedAny {
+		return 0, true
+	}
+	return st.allowed, true
+}
+
+// Mount adds a prefix route which dispatches requests to sub.
+// sub matches the part of the path that follows the prefix, e.g. after Mount("/admin/", sub)
+// a request for "/admin/users" is matched by sub as "/users".
+// When no route of sub matches a request, matching continues with the routes registered after
+// the mount point, as if the mount point did not match.
+func (r *Router) Mount(pattern string, sub *Router) (*Route, error) {
+	if sub == r {
+		return nil, fmt.Errorf("can not mount router %p onto itself", r)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(pattern), "/") {
+		return nil, fmt.Errorf("invalid mount pattern %q; must end in \"/\"", pattern)
+	}
+	route, err := r.Add(pattern, nil)
+	if route != nil {
+		route.Sub = sub
+	}
+	return route, err
+}
+
+// lookup finds the best match for path, relative to r.BasePath.
+// Returns false if path is outside BasePath or no route matches.
+func (r *Router) lookup(st *matchState, path string) bool {
 	// trim BasePath off of URL path
 	if len(r.BasePath) > 0 {
 		// when BasePath is non-empty it...
@@ -51,40 +230,18 @@ func (r *Router) Match(conditions CondFlags, path string) (*Match, error) {
 		// - is never just "/"
 		//
 		if !strings.HasPrefix(path, r.BasePath) {
-			return nil, fmt.Errorf("requested path %q outside of BasePath %q", path, r.BasePath)
+			return false
 		}
 		path = path[len(r.BasePath):]
 	}
 
-	// This could be a lot more efficient with something fancy like a b-tree.
-	// For now, keep it simple and just do a linear scan.
-	for i := range r.Routes {
-		route := &r.Routes[i]
-
-		// check conditions
-		if route.Conditions != 0 && (route.Conditions&conditions) == 0 {
-			continue
-		}
-
-		// check constant prefix
-		if len(route.EntryPrefix) > 0 && !strings.HasPrefix(path, route.EntryPrefix) {
-			continue
-		}
-
-		if route.Pattern == nil {
-			// no variables
-			if route.IsPrefix || path == route.EntryPrefix {
-				return &Match{Route: route, Path: path}, nil
-			}
-		} else {
-			// check regexp
-			values := route.Pattern.FindStringSubmatch(path)
-			if len(values) == 1+len(route.Vars) {
-				return &Match{Route: route, Path: path, values: values[1:]}, nil
-			}
-		}
+	// All route patterns start with "/"
+	if r.tree == nil || len(path) == 0 || path[0] != '/' {
+		return false
 	}
 
-	// no route found
-	return nil, nil
+	// Look up the earliest-registered route that matches, using the segment tree.
+	st.path = path
+	r.tree.match(st, 0)
+	return st.route != nil || st.nallowed > 0
 }
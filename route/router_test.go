@@ -1,6 +1,12 @@
 package route
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/rsms/go-testutil"
@@ -45,3 +51,830 @@ func TestRouter(t *testing.T) {
 	assert.Eq("Vars", m.Vars()["id"], "bob")
 	assert.Eq("Vars", m.Vars()["action"], "lol")
 }
+
+// linearMatch is the reference implementation of Router.Match: a linear scan over all routes
+func linearMatch(r *Router, conditions CondFlags, path string) *Match {
+	m := linearMatch1(r, conditions, path, false)
+	if m != nil && m.ImplicitHEAD && conditions == CondMethodHEAD {
+		// routes which explicitly list HEAD are preferred to GET routes
+		if m2 := linearMatch1(r, conditions, path, true); m2 != nil {
+			return m2
+		}
+	}
+	return m
+}
+
+func linearMatch1(r *Router, conditions CondFlags, path string, explicitHEAD bool) *Match {
+	path = path[len(r.BasePath):]
+	for _, route := range r.Routes {
+		if route.Conditions != 0 && (route.Conditions&conditions) == 0 {
+			continue
+		}
+		if explicitHEAD && (route.ImplicitHEAD || route.Conditions == 0) {
+			continue
+		}
+		if len(route.EntryPrefix) > 0 && !strings.HasPrefix(path, route.EntryPrefix) {
+			continue
+		}
+		if route.Pattern == nil {
+			if route.IsPrefix || path == route.EntryPrefix {
+				return &Match{Route: route, Path: path}
+			}
+		} else {
+			values := route.Pattern.FindStringSubmatch(path)
+			if len(values) == 1+len(route.Vars) {
+				return &Match{Route: route, Path: path, values: values[1:]}
+			}
+		}
+	}
+	return nil
+}
+
+func TestRouterTree(t *testing.T) {
+	testRouterTree(t, false)
+	testRouterTree(t, true)
+}
+
+func testRouterTree(t *testing.T, orderBySpecificity bool) {
+	assert := testutil.NewAssert(t)
+	r := Router{OrderBySpecificity: orderBySpecificity}
+	patterns := []string{
+		"GET /",
+		"/a/b",
+		"/a/b/!",
+		"POST /a/{x}",
+		"/a/{x}/c",
+		"/a/{x}.json",
+		"/a/{x:[0-9]+}/{y}",
+		"/a/b/{rest:.*}",
+		"/files/",
+		"/files/{dir}/",
+		"GET|HEAD /files/{dir}/{name}.{ext:[a-z]+}",
+		"/u/{id:[0-9a-f]{4}}",
+		"/x{y}/z",
+		"HEAD /a/{x}",
+		"/",
+	}
+	for i, pattern := range patterns {
+		// some patterns are shadowed by earlier ones, which is fine for this test
+		if _, err := r.Add(pattern, i); err != nil {
+			_, ok := err.(*ConflictError)
+			assert.Ok(pattern+": "+err.Error(), ok)
+		}
+	}
+	paths := []string{
+		"/", "/a", "/a/", "/a/b", "/a/b/", "/a/b/c/d", "/a/bob", "/a/bob/c", "/a/bob.json",
+		"/a/123/c", "/a/123/x", "/files", "/files/", "/files/docs", "/files/docs/",
+		"/files/docs/readme.txt", "/files/docs/readme.TXT", "/u/beef", "/u/bee", "/xyz/z", "/x/z",
+	}
+	for _, cond := range []CondFlags{CondMethodGET, CondMethodPOST, CondMethodHEAD} {
+		for _, path := range paths {
+			m1, err := r.Match(cond, path)
+			assert.NoErr(path, err)
+			m2 := linearMatch(&r, cond, path)
+			if m2 == nil {
+				assert.Ok(cond.String()+" "+path+" should not match", m1 == nil)
+				continue
+			}
+			if !assert.Ok(cond.String()+" "+path+" should match", m1 != nil) {
+				continue
+			}
+			assert.Eq(cond.String()+" "+path+" route", m1.Handler, m2.Handler)
+			assert.Eq(cond.String()+" "+path+" values",
+				fmt.Sprintf("%q", m1.Values()), fmt.Sprintf("%q", m2.Values()))
+		}
+	}
+}
+
+func makeBenchRouter() *Router {
+	var r Router
+	for i := 0; i < 100; i++ {
+		r.Add(fmt.Sprintf("GET /api/v1/thing%d/{id:[0-9]+}", i), i)
+		r.Add(fmt.Sprintf("GET|POST /api/v1/thing%d/{id}/edit", i), i)
+		r.Add(fmt.Sprintf("GET /page%d", i), i)
+	}
+	r.Add("GET /", -1)
+	return &r
+}
+
+var benchPaths = []string{"/api/v1/thing50/123", "/api/v1/thing99/abc/edit", "/page42", "/nope"}
+
+func BenchmarkRouterMatchTree(b *testing.B) {
+	r := makeBenchRouter()
+	for i := 0; i < b.N; i++ {
+		r.Match(CondMethodGET, benchPaths[i%len(benchPaths)])
+	}
+}
+
+func BenchmarkRouterMatchLinear(b *testing.B) {
+	r := makeBenchRouter()
+	for i := 0; i < b.N; i++ {
+		linearMatch(r, CondMethodGET, benchPaths[i%len(benchPaths)])
+	}
+}
+
+func TestRouterAllowed(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	r.Add("GET /a/{x}", 1)
+	r.Add("POST|PUT /a/{x:[0-9]+}", 2)
+	r.Add("/b", 3)
+
+	conds, ok := r.Allowed("", "/a/123")
+	assert.Ok("/a/123 matches some route", ok)
+	assert.Eq("/a/123 conditions", conds.String(), "GET|HEAD|POST|PUT")
+
+	conds, ok = r.Allowed("", "/a/bob")
+	assert.Ok("/a/bob matches some route", ok)
+	assert.Eq("/a/bob conditions", conds.String(), "GET|HEAD")
+
+	conds, ok = r.Allowed("", "/b")
+	assert.Ok("/b matches some route", ok)
+	assert.Eq("/b conditions", conds, CondFlags(0))
+
+	_, ok = r.Allowed("", "/c")
+	assert.Ok("/c matches no route", !ok)
+}
+
+func TestRouteImplicitHEAD(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	r1, _ := r.Add("GET /a", 1)
+	r2, _ := r.Add("GET /b", 2)
+	r3, _ := r.Add("GET|HEAD /c", 3)
+	assert.Ok("GET route is implicitly HEAD", r1.ImplicitHEAD)
+	assert.Ok("GET|HEAD route is explicitly HEAD", !r3.ImplicitHEAD)
+
+	r2.DisableImplicitHEAD()
+	r3.DisableImplicitHEAD()
+
+	m, _ := r.Match(CondMethodHEAD, "/a")
+	assert.Ok("HEAD /a matches GET route", m != nil && m.Handler.(int) == 1)
+	m, _ = r.Match(CondMethodHEAD, "/b")
+	assert.Ok("HEAD /b does not match after DisableImplicitHEAD", m == nil)
+	m, _ = r.Match(CondMethodHEAD, "/c")
+	assert.Ok("HEAD /c still matches explicit HEAD route", m != nil && m.Handler.(int) == 3)
+
+	// an explicit HEAD route wins over an implicit one, even when added later
+	_, err := r.Add("HEAD /a", 4)
+	assert.NoErr("explicit HEAD route is not shadowed by GET route", err)
+	r.Add("GET /{x}", 5)
+	r.Add("HEAD /d", 6)
+	m, _ = r.Match(CondMethodHEAD, "/a")
+	assert.Ok("HEAD /a matches explicit HEAD route", m != nil && m.Handler.(int) == 4)
+	m, _ = r.Match(CondMethodGET, "/a")
+	assert.Ok("GET /a matches GET route", m != nil && m.Handler.(int) == 1)
+	m, _ = r.Match(CondMethodHEAD, "/d")
+	assert.Ok("HEAD /d matches explicit HEAD route", m != nil && m.Handler.(int) == 6)
+	m, _ = r.Match(CondMethodHEAD, "/e")
+	assert.Ok("HEAD /e matches GET route", m != nil && m.Handler.(int) == 5)
+}
+
+func TestRouterHost(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	_, err := r.Add("GET api.example.com/v1/{x}", 1)
+	assert.NoErr("host pattern", err)
+	_, err = r.Add("{tenant}.example.com/v1/{x}", 2)
+	assert.NoErr("host pattern with var", err)
+	_, err = r.Add("GET|POST *.example.org/", 3)
+	assert.NoErr("host pattern with wildcard", err)
+	_, err = r.Add("GET|POST/", 4)
+	assert.NoErr("conditions directly followed by path", err)
+	_, err = r.Add("{x}.example.com/{x}", 5)
+	assert.Err("duplicate var in host and path", "duplicate var", err)
+
+	m, _ := r.MatchHost(CondMethodGET, "API.example.com", "/v1/a")
+	assert.Eq("literal host", m.Handler, 1)
+	assert.Eq("literal host var", m.Var("x"), "a")
+
+	m, _ = r.MatchHost(CondMethodGET, "acme.example.com", "/v1/a")
+	assert.Eq("host var", m.Handler, 2)
+	assert.Eq("host var tenant", m.Var("tenant"), "acme")
+	assert.Eq("host var x", m.Var("x"), "a")
+	assert.Eq("host var values", fmt.Sprintf("%q", m.Values()), `["acme" "a"]`)
+
+	m, _ = r.MatchHost(CondMethodGET, "www.example.org", "/foo")
+	assert.Eq("wildcard host", m.Handler, 3)
+
+	m, _ = r.MatchHost(CondMethodGET, "a.b.example.org", "/foo")
+	assert.Eq("wildcard matches a single label", m.Handler, 4)
+
+	m, _ = r.Match(CondMethodGET, "/v1/a")
+	assert.Eq("host routes do not match without host", m.Handler, 4)
+}
+
+func TestRouterMount(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var admin Router
+	admin.Add("GET /users/{id}", "admin-user")
+	admin.Add("POST /users/", "admin-users")
+
+	var org Router
+	org.Add("/settings", "org-settings")
+
+	var r Router
+	_, err := r.Mount("/admin", &admin)
+	assert.Err("mount pattern must be a prefix", "must end in", err)
+	_, err = r.Mount("/admin/", &admin)
+	assert.NoErr("Mount", err)
+	_, err = r.Mount("/org/{org}/", &org)
+	assert.NoErr("Mount with var", err)
+	r.Add("/", "fallback")
+
+	m, _ := r.Match(CondMethodGET, "/admin/users/bob")
+	assert.Eq("mounted route", m.Handler, "admin-user")
+	assert.Eq("path relative to mount", m.Path, "/users/bob")
+	assert.Eq("mounted route var", m.Var("id"), "bob")
+
+	m, _ = r.Match(CondMethodGET, "/admin/nothing")
+	assert.Eq("falls through when mounted router does not match", m.Handler, "fallback")
+
+	m, _ = r.Match(CondMethodGET, "/org/acme/settings")
+	assert.Eq("route mounted at var prefix", m.Handler, "org-settings")
+	assert.Eq("parent var", m.Var("org"), "acme")
+	assert.Eq("Vars includes parent vars", m.Vars()["org"], "acme")
+
+	conds, ok := r.Allowed("", "/admin/users/bob")
+	assert.Ok("Allowed includes mounted routes", ok)
+	assert.Eq("Allowed conditions", conds, CondFlags(0)) // "/" is unconditional
+
+	admin.BasePath = "/v1"
+	m, _ = r.Match(CondMethodGET, "/admin/users/bob")
+	assert.Eq("outside BasePath of mounted router", m.Handler, "fallback")
+}
+
+func TestRouterURL(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r, admin Router
+	r.BasePath = "/app"
+	route, _ := r.Add("GET /user/{id:[0-9]+}/{action}", 1)
+	route.Name = "user"
+	route, _ = admin.Add("/settings/{key}", 2)
+	route.Name = "settings"
+	r.Mount("/org/{org}/", &admin)
+
+	u, err := r.URL("user", "id", "123", "action", "edit")
+	assert.NoErr("URL", err)
+	assert.Eq("URL", u, "/app/user/123/edit")
+
+	_, err = r.URL("user", "id", "abc", "action", "edit")
+	assert.Err("URL with invalid var value", "does not match", err)
+
+	_, err = r.URL("user", "id", "123")
+	assert.Err("URL with missing var", "missing value", err)
+
+	_, err = r.URL("nope")
+	assert.Ok("URL with unknown name", errors.Is(err, ErrUnknownRoute))
+
+	u, err = r.URL("settings", "org", "acme", "key", "a b")
+	assert.NoErr("URL of mounted route", err)
+	assert.Eq("URL of mounted route", u, "/app/org/acme/settings/a%20b")
+}
+
+func TestRouterTypedVars(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	r.Add("/user/{id:int}", 1)
+	r.Add("/post/{slug:slug}", 2)
+	r.Add("/doc/{u:uuid}", 3)
+	r.Add("/day/{d:date}", 4)
+
+	m, _ := r.Match(CondMethodGET, "/user/-42")
+	assert.Eq("int var", m.Handler, 1)
+	v, err := m.Value("id")
+	assert.NoErr("int var value", err)
+	assert.Eq("int var value", v, -42)
+	m, _ = r.Match(CondMethodGET, "/user/abc")
+	assert.Ok("int var does not match letters", m == nil)
+
+	m, _ = r.Match(CondMethodGET, "/post/hello-world")
+	assert.Eq("slug var", m.Handler, 2)
+	m, _ = r.Match(CondMethodGET, "/post/Hello--world")
+	assert.Ok("slug var does not match", m == nil)
+
+	m, _ = r.Match(CondMethodGET, "/doc/F81D4FAE-7DEC-11D0-A765-00A0C91E6BF6")
+	assert.Eq("uuid var", m.Handler, 3)
+	v, err = m.Value("u")
+	assert.NoErr("uuid var value", err)
+	id := v.([16]byte)
+	assert.Eq("uuid var value", id[:2], []byte{0xf8, 0x1d})
+
+	m, _ = r.Match(CondMethodGET, "/day/2020-02-30")
+	assert.Eq("date var", m.Handler, 4)
+	_, err = m.Value("d")
+	assert.Err("date var value out of range", "out of range", err)
+}
+
+func TestRouterVarPatterns(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	_, err := r.Add("/img/{name}.{ext:(png|jpe?g)}", 1)
+	assert.NoErr("capture group in var pattern", err)
+	_, err = r.Add("/n/{n:^([0-9]{1,3})$}/{x:(?P<y>a)|b}", 2)
+	assert.NoErr("anchors, braces and named group in var pattern", err)
+	_, err = r.Add("/any/{p:(.*)}", 3)
+	assert.NoErr("capture group in var pattern matching across segments", err)
+
+	m, _ := r.Match(CondMethodGET, "/img/cat.jpeg")
+	assert.Eq("capture group", m.Handler, 1)
+	assert.Eq("capture group vars", fmt.Sprintf("%q", m.Vars()), `map["ext":"jpeg" "name":"cat"]`)
+
+	m, _ = r.Match(CondMethodGET, "/n/123/b")
+	assert.Eq("anchors and braces", m.Handler, 2)
+	assert.Eq("anchors and braces values", fmt.Sprintf("%q", m.Values()), `["123" "b"]`)
+	m, _ = r.Match(CondMethodGET, "/n/1234/b")
+	assert.Ok("repetition limit in var pattern", m == nil)
+
+	m, _ = r.Match(CondMethodGET, "/any/a/b")
+	assert.Eq("capture group across segments", m.Handler, 3)
+	assert.Eq("capture group across segments value", m.Var("p"), "a/b")
+
+	_, err = r.Add("/x/{a:[0-9}", 0)
+	assert.Err("unterminated var", "unterminated var", err)
+	_, err = r.Add("/x/{a:a(b}", 0)
+	assert.Err("invalid var pattern", "invalid pattern for var \"a\"", err)
+	_, err = r.Add("/x/{a:a$b}", 0)
+	assert.Err("anchor inside var pattern", "only allowed at the start or end", err)
+	_, err = r.Add("/x/{a:[^\\x00-\\x{10FFFF}]}", 0)
+	assert.Err("var pattern which never matches", "never matches", err)
+}
+
+func TestRouterSpecificity(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	r := Router{OrderBySpecificity: true}
+	patterns := []string{
+		"/",
+		"/a/{x}",
+		"/a/{x}/",
+		"/a/{x:[0-9]+}",
+		"/a/b",
+		"/a/{rest:.*}",
+		"/a/{x}.json",
+		"POST /a/b",
+		"/a/b/",
+	}
+	for i, pattern := range patterns {
+		_, err := r.Add(pattern, i)
+		assert.NoErr(pattern, err)
+	}
+	var order []string
+	for _, route := range r.Routes {
+		order = append(order, route.Source)
+	}
+	assert.Eq("order", strings.Join(order, "\n"), strings.Join([]string{
+		"POST /a/b",
+		"/a/b",
+		"/a/b/",
+		"/a/{x}.json",
+		"/a/{x:[0-9]+}",
+		"/a/{x}",
+		"/a/{x}/",
+		"/a/{rest:.*}",
+		"/",
+	}, "\n"))
+	for path, handler := range map[string]int{
+		"/a/b":      4,
+		"/a/b/c":    8,
+		"/a/1":      3,
+		"/a/x.json": 6,
+		"/a/x":      1,
+		"/a/x/y":    2,
+		"/a/":       5,
+		"/b":        0,
+	} {
+		m, _ := r.Match(CondMethodGET, path)
+		if assert.Ok(path+" should match", m != nil) {
+			assert.Eq(path, m.Handler, handler)
+		}
+	}
+	m, _ := r.Match(CondMethodPOST, "/a/b")
+	assert.Eq("POST /a/b", m.Handler, 7)
+}
+
+func TestRouterConflict(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	add := func(r *Router, pattern string) error {
+		_, err := r.Add(pattern, pattern)
+		return err
+	}
+	conflicts := []struct {
+		earlier, later string
+	}{
+		{"/a/{x}", "/a/b"},
+		{"/a/{x}", "/a/{y:[0-9]+}"},
+		{"/a/{x}", "POST /a/{y}"},
+		{"GET|HEAD /a/{x}", "HEAD /a/{y}"},
+		{"/a/", "/a/b/c"},
+		{"/", "/a/{x}/"},
+		{"/a/{x:[0-9]+}", "/a/42"},
+		{"/a/{x}.{y}", "/a/{x}.{y}"},
+		{"/a/{rest:.*}", "/a/{more:.*}"},
+		{"/a/b", "example.com/a/b"},
+		{"example.com/a/b", "EXAMPLE.com/a/b"},
+	}
+	for _, c := range conflicts {
+		var r Router
+		assert.NoErr(c.earlier, add(&r, c.earlier))
+		err := add(&r, c.later)
+		if assert.Err(c.later, "unreachable", err) {
+			e := err.(*ConflictError)
+			assert.Eq(c.later+" shadowed by", e.ShadowedBy.Source, c.earlier)
+			assert.Eq(c.later+" still added", len(r.Routes), 2)
+		}
+	}
+
+	nonConflicts := []struct {
+		earlier, later string
+	}{
+		{"/a/b", "/a/{x}"},
+		{"POST /a/{x}", "/a/{y}"},
+		{"GET /a/{x}", "POST /a/{y}"},
+		{"GET /a/{x}", "HEAD /a/{y}"}, // explicit HEAD wins over the HEAD implied by GET
+		{"/a/{x:[0-9]+}", "/a/{y}"},
+		{"/a/{x:[0-9]+}", "/a/b"},
+		{"/a/{x}", "/a/{x}/"},
+		{"/a/{x}", "/a/"},
+		{"/a/b/", "/a/b"},
+		{"/a/{rest:.*}", "/a/b"}, // not analyzed
+		{"example.com/a/b", "/a/b"},
+	}
+	for _, c := range nonConflicts {
+		var r Router
+		assert.NoErr(c.earlier, add(&r, c.earlier))
+		assert.NoErr(c.later+" after "+c.earlier, add(&r, c.later))
+	}
+
+	// mount points fall through and never shadow routes
+	var r, sub Router
+	_, err := r.Mount("/a/", &sub)
+	assert.NoErr("mount", err)
+	assert.NoErr("/a/b after mount", add(&r, "/a/b"))
+
+	// with OrderBySpecificity, more specific routes are never shadowed by broader ones
+	r = Router{OrderBySpecificity: true}
+	assert.NoErr("/a/{x}", add(&r, "/a/{x}"))
+	assert.NoErr("/a/b", add(&r, "/a/b"))
+}
+
+func TestRouterRestVar(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	_, err := r.Add("/files/{path...}", 1)
+	assert.NoErr("rest var", err)
+	_, err = r.Add("/u/{user}/{rest...}", 2)
+	assert.NoErr("rest var after var", err)
+	r.Add("/u/{user}", 3)
+
+	for path, expect := range map[string]string{
+		"/files/":            "",
+		"/files/a":           "a",
+		"/files/a/b/c.txt":   "a/b/c.txt",
+		"/files/a//b/":       "a/b",
+		"/files/a/../../etc": "etc",
+	} {
+		m, _ := r.Match(CondMethodGET, path)
+		if assert.Ok(path+" should match", m != nil) {
+			assert.Eq(path, m.Var("path"), expect)
+		}
+	}
+	m, _ := r.Match(CondMethodGET, "/files")
+	assert.Ok("/files should not match", m == nil)
+
+	m, _ = r.Match(CondMethodGET, "/u/bob/x/y")
+	assert.Eq("/u/bob/x/y", fmt.Sprintf("%q", m.Vars()), `map["rest":"x/y" "user":"bob"]`)
+	m, _ = r.Match(CondMethodGET, "/u/bob")
+	assert.Eq("/u/bob", m.Handler, 3)
+
+	_, err = r.Add("/x/{path...}/y", 0)
+	assert.Err("rest var not at end", "must be at the end", err)
+	_, err = r.Add("{x...}.example.com/", 0)
+	assert.Err("rest var in host", "not allowed in route host", err)
+	_, err = r.Add("/files/{p...}", 0)
+	assert.Err("rest var conflict", "unreachable", err)
+
+	r.Routes[0].Name = "file"
+	url, err := r.URL("file", "path", "a/b c")
+	assert.NoErr("URL with rest var", err)
+	assert.Eq("URL with rest var", url, "/files/a/b%20c")
+}
+
+func TestRouterCustomMethods(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	_, err := r.Add("PROPFIND|MKCOL /dav/", 1)
+	assert.Err("unregistered methods", "unknown method \"PROPFIND\"", err)
+	_, err = r.Add("GTE /x", 1)
+	assert.Err("misspelled method", "unknown method \"GTE\"", err)
+	assert.Eq("misspelled method is not registered", MethodCond("GTE"), CondFlags(0))
+
+	for _, method := range []string{"PROPFIND", "MKCOL", "LOCK"} {
+		_, err = RegisterMethod(method)
+		assert.NoErr("register "+method, err)
+	}
+	_, err = r.Add("PROPFIND|MKCOL /dav/", 1)
+	assert.NoErr("custom methods", err)
+	_, err = r.Add("GET|LOCK /dav/", 2)
+	assert.NoErr("standard and custom methods", err)
+
+	propfind := MethodCond("PROPFIND")
+	assert.Ok("PROPFIND is registered", propfind != 0)
+	f, err := RegisterMethod("PROPFIND")
+	assert.NoErr("register again", err)
+	assert.Eq("register again returns existing flag", f, propfind)
+	assert.Eq("standard method", MethodCond("POST"), CondFlags(CondMethodPOST))
+	assert.Eq("unknown method", MethodCond("UNKNOWN-METHOD"), CondFlags(0))
+
+	m, _ := r.Match(propfind, "/dav/a")
+	assert.Eq("PROPFIND /dav/a", m.Handler, 1)
+	m, _ = r.Match(MethodCond("LOCK"), "/dav/a")
+	assert.Eq("LOCK /dav/a", m.Handler, 2)
+	m, _ = r.Match(MethodCond("UNKNOWN-METHOD"), "/dav/a")
+	assert.Ok("unknown method does not match", m == nil)
+
+	allowed, _ := r.Allowed("", "/dav/a")
+	assert.Eq("allowed methods", allowed.String(), "GET|HEAD|PROPFIND|MKCOL|LOCK")
+
+	_, err = r.Add("get /x", 0)
+	assert.Err("lowercase method", "invalid condition", err)
+	_, err = RegisterMethod("A B")
+	assert.Err("invalid method", "invalid condition", err)
+}
+
+func TestRouterAttrConds(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	for i, pattern := range []string{
+		"POST+json /api/x",
+		"POST | PUT+form /api/x",
+		"https: /secure/",
+		"GET [X-Requested-With=XMLHttpRequest] /page",
+		"GET ?format=csv /page",
+		"GET [accept=text/html] ?debug example.com/page",
+		"/page",
+		"GET ?next=/a [Accept=text/html] /login",
+	} {
+		_, err := r.Add(pattern, i)
+		assert.NoErr(pattern, err)
+	}
+	assert.Eq("parsed", r.Routes[1].String(), "{POST|PUT +form /api/x}")
+	assert.Eq("parsed", r.Routes[5].String(), "{GET|HEAD [Accept=text/html] ?debug example.com/page}")
+
+	match := func(method, target string, header ...string) interface{} {
+		req := httptest.NewRequest(method, target, nil)
+		req.Host = "example.com"
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		m, err := r.MatchRequest(req)
+		assert.NoErr(target, err)
+		if m == nil {
+			return nil
+		}
+		return m.Handler
+	}
+	assert.Eq("json", match("POST", "/api/x", "Content-Type", "application/json; charset=utf-8"), 0)
+	assert.Eq("json suffix", match("POST", "/api/x", "Content-Type", "application/ld+json"), 0)
+	assert.Eq("form", match("PUT", "/api/x", "Content-Type", "application/x-www-form-urlencoded"), 1)
+	assert.Eq("wrong content type", match("POST", "/api/x", "Content-Type", "text/plain"), nil)
+	assert.Eq("http", match("GET", "/secure/a"), nil)
+	assert.Eq("https", match("GET", "https://example.com/secure/a"), 2)
+	assert.Eq("xhr", match("GET", "/page", "X-Requested-With", "XMLHttpRequest"), 3)
+	assert.Eq("query", match("GET", "/page?format=csv"), 4)
+	assert.Eq("query value", match("GET", "/page?format=json"), 6)
+	assert.Eq("header and query", match("GET", "/page?debug", "Accept", "text/html"), 5)
+	assert.Eq("no attributes", match("GET", "/page"), 6)
+	assert.Eq("slash in query condition", r.Routes[7].String(),
+		"{GET|HEAD ?next=/a [Accept=text/html] /login}")
+	assert.Eq("query value with slash", match("GET", "/login?next=/a", "Accept", "text/html"), 7)
+
+	m, _ := r.Match(CondMethodPOST, "/api/x")
+	assert.Ok("Match without request never matches attribute conditions", m == nil)
+
+	_, err := r.Add("POST+nope /x", 0)
+	assert.Err("unknown content type", "unknown content type", err)
+	_, err = r.Add("[X:Y] /x", 0)
+	assert.Err("invalid header name", "invalid header name", err)
+	_, err = r.Add("[::1]/x", 0)
+	assert.NoErr("IPv6 host", err)
+	_, err = r.Add("GET ?next=/a", 0)
+	assert.Err("query condition without path", "missing leading", err)
+	_, err = r.Add("GET ?format=csv /page", 0)
+	assert.Err("same attributes conflict", "unreachable", err)
+}
+
+func TestRouterCloneRemove(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	a, _ := r.Add("/a", 1)
+	b, _ := r.Add("/{x}", 2)
+	r.Add("/c", 3)
+
+	r2 := r.Clone()
+	assert.Ok("remove by handle from clone", r2.Remove(a))
+	assert.Ok("remove twice", !r2.Remove(a))
+	assert.Eq("routes of clone", len(r2.Routes), 2)
+	m, _ := r2.Match(CondMethodGET, "/a")
+	assert.Eq("/a in clone", m.Handler, 2)
+	m, _ = r2.Match(CondMethodGET, "/c")
+	assert.Eq("/c in clone", m.Handler, 2)
+
+	// r is unaffected
+	assert.Eq("routes of original", len(r.Routes), 3)
+	m, _ = r.Match(CondMethodGET, "/a")
+	assert.Eq("/a in original", m.Handler, 1)
+
+	assert.Ok("remove from original", r.Remove(b))
+	m, _ = r.Match(CondMethodGET, "/c")
+	assert.Eq("/c after removing /{x}", m.Handler, 3)
+	r.Add("/{y}", 4)
+	m, _ = r.Match(CondMethodGET, "/d")
+	assert.Eq("route added after remove", m.Handler, 4)
+}
+
+func TestRouterExplain(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r, sub Router
+	r.Add("POST /a", 1)
+	r.Add("example.com/a", 2)
+	r.Add("GET+json /a", 3)
+	r.Mount("/s/", &sub)
+	r.Add("/{x}", TestRouterExplain)
+	sub.Add("/b/{y:int}", 5)
+	r.Routes[4].Name = "x"
+
+	// reasons returns the explanations, one per line
+	reasons := func(expl []Explanation) string {
+		var v []string
+		for _, e := range expl {
+			if e.Matched {
+				v = append(v, e.Pattern+": matched")
+			} else {
+				v = append(v, e.Pattern+": "+e.Reason)
+			}
+		}
+		return strings.Join(v, "\n")
+	}
+	assert.Eq("explain /a", reasons(r.Explain("GET", "other.com", "/a")), strings.Join([]string{
+		"POST /a: method GET is not one of POST",
+		"example.com/a: host \"other.com\" does not match \"example.com\"",
+		"GET+json /a: condition +json is not satisfied",
+		"/s/: path does not match",
+		"/{x}: matched",
+	}, "\n"))
+	expl := r.Explain("GET", "", "/s/b/1")
+	assert.Eq("explain mount", reasons(expl[3:4]), "/s/: matched")
+	assert.Eq("explain mounted", reasons(expl[3].Mount), "/b/{y:int}: matched")
+	expl = r.Explain("GET", "", "/s/b/c")
+	assert.Eq("explain mount without match", reasons(expl[3:]), strings.Join([]string{
+		"/s/: no route of the mounted router matches",
+		"/{x}: path does not match",
+	}, "\n"))
+
+	// HEAD requests are matched by routes which list HEAD in preference to GET routes
+	var r2, sub2 Router
+	r2.Add("GET /a", 1)
+	r2.Add("/a", 2)
+	r2.Add("HEAD /a", 3)
+	r2.Mount("/s/", &sub2)
+	r2.Add("HEAD /s/b", 4)
+	sub2.Add("GET /b", 5)
+	assert.Eq("explain implicit HEAD", reasons(r2.Explain("HEAD", "", "/a")), strings.Join([]string{
+		"GET /a: a route which lists HEAD explicitly is preferred",
+		"/a: a route which lists HEAD explicitly is preferred",
+		"HEAD /a: matched",
+	}, "\n"))
+	assert.Eq("explain GET", reasons(r2.Explain("GET", "", "/a")), "GET /a: matched")
+	expl = r2.Explain("HEAD", "", "/s/b")
+	assert.Eq("explain implicit HEAD of mounted route", reasons(expl[3:]), strings.Join([]string{
+		"/s/: no route of the mounted router matches",
+		"HEAD /s/b: matched",
+	}, "\n"))
+	for _, target := range []string{"/a", "/s/b"} {
+		m, _ := r2.Match(CondMethodHEAD, target)
+		expl = r2.Explain("HEAD", "", target)
+		assert.Eq("Explain agrees with Match: "+target, matchedRoute(expl), m.Route)
+	}
+	r2.Remove(r2.Routes[2])
+	assert.Eq("explain implicit HEAD without HEAD route",
+		reasons(r2.Explain("HEAD", "", "/a")), "GET /a: matched")
+
+	table := r.RouteTable()
+	assert.Eq("table size", len(table), 5)
+	assert.Eq("conditions", strings.Join(table[2].Conditions, " "), "GET HEAD +json")
+	assert.Eq("host", table[1].Host, "example.com")
+	assert.Eq("vars", strings.Join(table[4].Vars, " "), "x")
+	assert.Eq("name", table[4].Name, "x")
+	assert.Eq("func handler", table[4].Handler, "github.com/rsms/go-httpd/route.TestRouterExplain")
+	assert.Eq("handler type", table[0].Handler, "int")
+	assert.Eq("mount", table[3].Mount[0].Pattern, "/b/{y:int}")
+	assert.Eq("mount vars", strings.Join(table[3].Mount[0].Vars, " "), "y")
+}
+
+func TestRouterOptionalGroups(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	var r Router
+	blog, err := r.Add(`GET /blog{/page/{n:\d+}}?`, 1)
+	assert.NoErr("optional group", err)
+	_, err = r.Add("/a/{x:[a-z]+}{.{ext:[a-z]+}}?", 2)
+	assert.NoErr("optional group in segment", err)
+	_, err = r.Add("/n{/{a}{/{b}}?}?", 3)
+	assert.NoErr("nested optional groups", err)
+	_, err = r.Add("/files{/{path...}}?", 4)
+	assert.NoErr("optional rest var", err)
+	_, err = r.Add("/lit{eral", 5)
+	assert.NoErr("literal brace", err)
+
+	m, _ := r.Match(CondMethodGET, "/blog")
+	if assert.Ok("/blog should match", m != nil) {
+		assert.Eq("/blog", m.Handler, 1)
+		assert.Eq("absent var", m.Var("n", "1"), "1")
+		assert.Eq("absent var without fallback", m.Var("n"), "")
+		assert.Eq("absent var not in Vars", len(m.Vars()), 0)
+		v, err := m.Value("n")
+		assert.Eq("absent var value", v, nil)
+		assert.NoErr("absent var value", err)
+	}
+	m, _ = r.Match(CondMethodGET, "/blog/page/3")
+	if assert.Ok("/blog/page/3 should match", m != nil) {
+		assert.Eq("present var", m.Var("n", "1"), "3")
+	}
+	m, _ = r.Match(CondMethodGET, "/blog/page/x")
+	assert.Ok("/blog/page/x should not match", m == nil)
+	m, _ = r.Match(CondMethodGET, "/blog/")
+	assert.Ok("/blog/ should not match", m == nil)
+
+	m, _ = r.Match(CondMethodGET, "/a/doc.txt")
+	assert.Eq("/a/doc.txt", fmt.Sprintf("%q", m.Vars()), `map["ext":"txt" "x":"doc"]`)
+	m, _ = r.Match(CondMethodGET, "/a/doc")
+	assert.Eq("/a/doc", fmt.Sprintf("%q", m.Vars()), `map["x":"doc"]`)
+
+	for path, vars := range map[string]string{
+		"/n":     `map[]`,
+		"/n/1":   `map["a":"1"]`,
+		"/n/1/2": `map["a":"1" "b":"2"]`,
+	} {
+		m, _ = r.Match(CondMethodGET, path)
+		if assert.Ok(path+" should match", m != nil) {
+			assert.Eq(path, fmt.Sprintf("%q", m.Vars()), vars)
+		}
+	}
+	m, _ = r.Match(CondMethodGET, "/files/a/b")
+	assert.Eq("/files/a/b", m.Var("path"), "a/b")
+	m, _ = r.Match(CondMethodGET, "/files")
+	assert.Eq("/files", m.Handler, 4)
+	m, _ = r.Match(CondMethodGET, "/lit{eral")
+	assert.Eq("literal brace", m.Handler, 5)
+
+	blog.Name = "blog"
+	url, err := r.URL("blog")
+	assert.NoErr("URL without optional var", err)
+	assert.Eq("URL without optional var", url, "/blog")
+	url, err = r.URL("blog", "n", "2")
+	assert.NoErr("URL with optional var", err)
+	assert.Eq("URL with optional var", url, "/blog/page/2")
+	_, err = r.URL("blog", "n", "x")
+	assert.Err("URL with invalid optional var", "invalid value", err)
+}
+
+func TestRouterEscapedPath(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	r := Router{EscapedPath: true}
+	r.Add("/doc/{id}", 1)
+	r.Add("/doc/{id}/edit", 2)
+	r.Add("/raw/{p...}", 3)
+	sub := &Router{}
+	sub.Add("/{x}", 4)
+	r.Mount("/sub/", sub)
+
+	match := func(rawurl string) *Match {
+		u, err := url.Parse(rawurl)
+		assert.NoErr(rawurl, err)
+		m, err := r.MatchRequest(&http.Request{Method: "GET", URL: u})
+		assert.NoErr(rawurl, err)
+		return m
+	}
+	m := match("/doc/a%2Fb")
+	if assert.Ok("/doc/a%2Fb should match", m != nil) {
+		assert.Eq("encoded slash", m.Handler, 1)
+		assert.Eq("decoded var", m.Var("id"), "a/b")
+	}
+	m = match("/doc/a%2Fb/edit")
+	assert.Eq("encoded slash followed by segment", m.Handler, 2)
+	m = match("/doc/a/b")
+	assert.Ok("/doc/a/b should not match", m == nil)
+	m = match("/doc/a%20b")
+	assert.Eq("encoded space", m.Var("id"), "a b")
+	m = match("/raw/a/b%2Fc")
+	assert.Eq("rest var", m.Var("p"), "a/b/c")
+	m = match("/sub/x%2Fy")
+	assert.Eq("mounted router", m.Var("x"), "x/y")
+
+	expl := r.Explain("GET", "", "/doc/a%2Fb")
+	assert.Eq("explain", expl[0].Matched, true)
+
+	// without EscapedPath the decoded path "/doc/a/b" is matched
+	r.EscapedPath = false
+	m = match("/doc/a%2Fb")
+	assert.Ok("decoded path should not match", m == nil)
+}
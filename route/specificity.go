@@ -0,0 +1,135 @@
+package route
+
+import "strings"
+
+// compareSpecificity returns a positive number if a is more specific than b, a negative
+// number if b is more specific than a and zero if they are equally specific.
+//
+// Segments are compared from left to right; a static segment beats a segment with a pattern
+// which in turn beats a plain "{name}" var. When all shared segments are equally specific, the
+// route with more segments wins, and when the routes have the same shape an exact pattern beats
+// one that matches across segments, which beats a prefix. Remaining ties are broken by the
+// length of the literal text, then by host, then by the number of attribute conditions and
+// finally by method conditions.
+func compareSpecificity(a, b *Route) int {
+	for i := 0; i < len(a.segs) && i < len(b.segs); i++ {
+		if d := segmentRank(a.segs[i]) - segmentRank(b.segs[i]); d != 0 {
+			return d
+		}
+	}
+	if d := len(a.segs) - len(b.segs); d != 0 {
+		return d
+	}
+	if d := openness(b) - openness(a); d != 0 {
+		return d
+	}
+	if d := literalLen(a) - literalLen(b); d != 0 {
+		return d
+	}
+	if d := boolRank(a.host != nil) - boolRank(b.host != nil); d != 0 {
+		return d
+	}
+	if d := len(a.Attrs) - len(b.Attrs); d != 0 {
+		return d
+	}
+	return boolRank(a.Conditions != 0) - boolRank(b.Conditions != 0)
+}
+
+func segmentRank(s segment) int {
+	switch s.kind {
+	case segStatic:
+		return 3
+	case segPattern:
+		return 2
+	}
+	return 1
+}
+
+// openness ranks how much of a path beyond its segments a route matches
+func openness(r *Route) int {
+	if r.IsPrefix {
+		return 2
+	}
+	if r.partial {
+		return 1
+	}
+	return 0
+}
+
+func literalLen(r *Route) int {
+	n := 0
+	for _, p := range r.parts {
+		if !p.isVar {
+			n += len(p.literal)
+		}
+	}
+	return n
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// covers returns true if r matches every request that r2 matches.
+// The check is conservative: false may be returned for some routes which do cover r2.
+func (r *Route) covers(r2 *Route) bool {
+	if r.Sub != nil {
+		return false // mounted routers fall through when they don't match
+	}
+	if r.Conditions != 0 && (r2.Conditions == 0 || r2.Conditions&^r.Conditions != 0) {
+		return false
+	}
+	if r.ImplicitHEAD && !r2.ImplicitHEAD && r2.Conditions&CondMethodHEAD != 0 {
+		return false // explicit HEAD routes are preferred to implicit ones
+	}
+	if r.host != nil && (r2.host == nil || !strings.EqualFold(r.Host, r2.Host)) {
+		return false
+	}
+	if !r2.hasAttrs(r) {
+		return false
+	}
+	if r.partial {
+		// we can't reason about arbitrary patterns; only identical ones
+		return r2.partial && r.Pattern.String() == r2.Pattern.String()
+	}
+	open2 := r2.IsPrefix || r2.partial
+	if r.IsPrefix {
+		if len(r2.segs) < len(r.segs) || (len(r2.segs) == len(r.segs) && !open2) {
+			return false
+		}
+	} else if open2 || len(r2.segs) != len(r.segs) {
+		return false
+	}
+	for i, s := range r.segs {
+		if !s.covers(r2.segs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// covers returns true if s matches every path segment that s2 matches
+func (s segment) covers(s2 segment) bool {
+	switch s.kind {
+	case segStatic:
+		return s2.kind == segStatic && s.value == s2.value
+	case segParam:
+		switch s2.kind {
+		case segStatic:
+			return len(s2.value) > 0
+		case segParam:
+			return true
+		}
+		return !s2.re.MatchString("")
+	}
+	switch s2.kind {
+	case segStatic:
+		return s.re.MatchString(s2.value) && s.nvars > 0
+	case segPattern:
+		return s.value == s2.value
+	}
+	return false
+}
@@ -0,0 +1,481 @@
+package route
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// patternPart is either a literal chunk, a variable or the start or end of an optional group
+// of a route pattern
+type patternPart struct {
+	literal string
+	group   int8 // 1 for the start of an optional group and -1 for its end
+	isVar   bool
+	name    string         // var name (only when isVar)
+	pattern string         // var pattern (only when isVar)
+	re      *regexp.Regexp // pattern anchored at both ends (only when isVar)
+}
+
+type segmentKind uint8
+
+const (
+	segStatic  = segmentKind(iota) // literal text, e.g. "foo" in "/foo/"
+	segParam                       // a single "{name}" var with the default pattern
+	segPattern                     // mix of literals and vars, e.g. "{name}.{ext:\w+}"
+)
+
+// segment is one "/"-delimited component of a route pattern
+type segment struct {
+	kind  segmentKind
+	value string         // literal text (segStatic) or regexp source (segPattern)
+	re    *regexp.Regexp // compiled value (segPattern)
+	nvars int            // number of vars in the segment
+}
+
+// parseSegments splits parts into path segments which can be matched by a tree.
+// Segments are collected up until the first segment with a var that may match across "/" or
+// with the start of an optional group, at which point r.partial is set and the remainder is
+// left for r.Pattern to match.
+func (r *Route) parseSegments(parts []patternPart) error {
+	var segparts [][]patternPart
+	var cur []patternPart
+	optional := false
+	for i, p := range parts {
+		if i == 0 {
+			// parts[0] is always a literal starting with "/"
+			p.literal = p.literal[1:]
+		}
+		if p.group != 0 {
+			// the segment where an optional group starts is left for r.Pattern to match
+			optional = true
+			break
+		}
+		if p.isVar {
+			cur = append(cur, p)
+			continue
+		}
+		lit := p.literal
+		for {
+			i := strings.IndexByte(lit, '/')
+			if i == -1 {
+				break
+			}
+			if i > 0 {
+				cur = append(cur, patternPart{literal: lit[:i]})
+			}
+			segparts = append(segparts, cur)
+			cur = nil
+			lit = lit[i+1:]
+		}
+		if len(lit) > 0 {
+			cur = append(cur, patternPart{literal: lit})
+		}
+	}
+	if !r.IsPrefix && !optional {
+		// a prefix pattern ends in "/" and thus cur is always empty for prefix patterns
+		segparts = append(segparts, cur)
+	}
+
+	r.segs = make([]segment, 0, len(segparts))
+	r.partial = optional
+	for _, sp := range segparts {
+		seg, ok, err := makeSegment(sp)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			r.partial = true
+			break
+		}
+		r.segs = append(r.segs, seg)
+	}
+	return nil
+}
+
+func makeSegment(parts []patternPart) (seg segment, ok bool, err error) {
+	if len(parts) == 0 {
+		return segment{kind: segStatic}, true, nil
+	}
+	if len(parts) == 1 {
+		if !parts[0].isVar {
+			return segment{kind: segStatic, value: parts[0].literal}, true, nil
+		}
+		if parts[0].pattern == defaultVarPattern {
+			return segment{kind: segParam, nvars: 1}, true, nil
+		}
+	}
+	var sb strings.Builder
+	sb.WriteByte('^')
+	for _, p := range parts {
+		if !p.isVar {
+			sb.WriteString(regexp.QuoteMeta(p.literal))
+			continue
+		}
+		if !isSegmentLocalPattern(p.pattern) {
+			return seg, false, nil
+		}
+		sb.WriteByte('(')
+		sb.WriteString(p.pattern)
+		sb.WriteByte(')')
+		seg.nvars++
+	}
+	sb.WriteByte('$')
+	seg.kind = segPattern
+	seg.value = sb.String()
+	seg.re, err = regexp.Compile(seg.value)
+	return seg, err == nil, err
+}
+
+// isSegmentLocalPattern returns true if the regular expression pattern can only ever match
+// text within a single path segment, i.e. it can not match "/" and has no anchors.
+func isSegmentLocalPattern(pattern string) bool {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return !mayCrossSegment(re)
+}
+
+func mayCrossSegment(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL,
+		syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	case syntax.OpLiteral:
+		for _, c := range re.Rune {
+			if c == '/' {
+				return true
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '/' && '/' <= re.Rune[i+1] {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if mayCrossSegment(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------------------------
+
+const maxInt = int(^uint(0) >> 1)
+
+// node is a segment tree node. Routes are stored at the node where their segments end.
+// All route lists are ordered by Route.index.
+type node struct {
+	static   map[string]*node // children for segStatic segments, keyed by literal text
+	param    *node            // child for segParam segments
+	patterns []*node          // children for segPattern segments
+	seg      segment          // segment leading to this node (only used for segPattern nodes)
+
+	leaves   []*Route // routes which end at this node
+	prefixes []*Route // prefix routes which end at this node
+	fallback []*Route // routes whose remainder must be matched with Route.Pattern
+
+	minIndex int // smallest Route.index in this node's subtree
+}
+
+func newNode() *node {
+	return &node{minIndex: maxInt}
+}
+
+// insert adds r to the tree. Routes must be inserted in order of Route.index.
+func (n *node) insert(r *Route) {
+	n.noteIndex(r.index)
+	for _, seg := range r.segs {
+		n = n.child(seg)
+		n.noteIndex(r.index)
+	}
+	switch {
+	case r.partial:
+		n.fallback = append(n.fallback, r)
+	case r.IsPrefix:
+		n.prefixes = append(n.prefixes, r)
+	default:
+		n.leaves = append(n.leaves, r)
+	}
+}
+
+func (n *node) noteIndex(index int) {
+	if index < n.minIndex {
+		n.minIndex = index
+	}
+}
+
+func (n *node) child(seg segment) *node {
+	switch seg.kind {
+	case segStatic:
+		c := n.static[seg.value]
+		if c == nil {
+			if n.static == nil {
+				n.static = make(map[string]*node)
+			}
+			c = newNode()
+			n.static[seg.value] = c
+		}
+		return c
+	case segParam:
+		if n.param == nil {
+			n.param = newNode()
+		}
+		return n.param
+	}
+	for _, c := range n.patterns {
+		if c.seg.value == seg.value {
+			return c
+		}
+	}
+	c := newNode()
+	c.seg = seg
+	n.patterns = append(n.patterns, c)
+	return c
+}
+
+// matchState holds the state of a tree lookup
+type matchState struct {
+	conditions   CondFlags
+	req          *http.Request // for attribute conditions; nil when matching without a request
+	host         string
+	escaped      bool // path is escaped; values must be unescaped (see Router.EscapedPath)
+	explicitHEAD bool // only match routes which explicitly list HEAD (see Router.match)
+	path         string
+	values       []string // var values of the current tree path
+
+	route   *Route      // best match so far
+	result  []string    // var values of route
+	absent  []bool      // vars of route in optional groups which did not match (nil if none)
+	mounted *matchState // state of route.Sub's lookup, when route is a mount point
+
+	// when collect is true, all routes matching path are visited regardless of conditions
+	collect    bool
+	allowed    CondFlags // union of conditions of all visited routes
+	allowedAny bool      // true if any visited route is unconditional
+	nallowed   int       // number of visited routes
+}
+
+func (st *matchState) allow(r *Route) {
+	st.allowed |= r.Conditions
+	st.allowedAny = st.allowedAny || r.Conditions == 0
+	st.nallowed++
+}
+
+// accept checks the conditions and host of r. hostValues are the values of any host vars.
+func (st *matchState) accept(r *Route) (hostValues []string, ok bool) {
+	if !st.collect {
+		if r.Conditions != 0 && (r.Conditions&st.conditions) == 0 {
+			return nil, false
+		}
+		if st.explicitHEAD && r.Sub == nil && (r.ImplicitHEAD || r.Conditions == 0) {
+			return nil, false
+		}
+		if len(r.Attrs) > 0 && !r.matchAttrs(st.req) {
+			return nil, false
+		}
+	}
+	if r.host == nil {
+		return nil, true
+	}
+	return r.host.match(st.host)
+}
+
+// better returns true if r would be a better match than the current best match
+func (st *matchState) better(r *Route) bool {
+	return st.route == nil || r.index < st.route.index
+}
+
+// take is called with a route r that matches st. rest is the remainder of the path
+// following a prefix route's prefix. Returns true if r became the best match.
+// absent, when not nil, tells which values are absent since their optional group did not match.
+func (st *matchState) take(r *Route, hostValues, values []string, absent []bool, rest string) bool {
+	var mounted *matchState
+	if r.Sub != nil {
+		mounted = &matchState{conditions: st.conditions, req: st.req, host: st.host,
+			escaped: st.escaped, explicitHEAD: st.explicitHEAD, collect: st.collect}
+		if !r.Sub.lookup(mounted, rest) {
+			return false
+		}
+	}
+	if st.collect {
+		if mounted != nil {
+			st.allowed |= mounted.allowed
+			st.allowedAny = st.allowedAny || mounted.allowedAny
+			st.nallowed += mounted.nallowed
+		} else {
+			st.allow(r)
+		}
+		return false
+	}
+	if st.escaped {
+		var ok bool
+		if values, ok = unescapeValues(values); !ok {
+			return false
+		}
+	}
+	st.route = r
+	st.result = append(append(st.result[:0], hostValues...), values...)
+	st.mounted = mounted
+	st.absent = nil
+	if absent != nil {
+		st.absent = append(make([]bool, len(hostValues)), absent...)
+	}
+	if r.hasRest {
+		last := len(st.result) - 1
+		st.result[last] = cleanRest(st.result[last])
+	}
+	return true
+}
+
+// makeMatch returns a Match for the best match of st (st.route must not be nil)
+func (st *matchState) makeMatch() *Match {
+	m := &Match{Route: st.route, Path: st.path, values: st.result, absent: st.absent}
+	if st.mounted == nil {
+		return m
+	}
+	// return the innermost match, with m at the top of its chain of parents
+	inner := st.mounted.makeMatch()
+	p := inner
+	for p.Parent != nil {
+		p = p.Parent
+	}
+	p.Parent = m
+	return inner
+}
+
+func (st *matchState) consider(routes []*Route, rest string) {
+	for _, r := range routes {
+		if !st.better(r) {
+			return
+		}
+		hostValues, ok := st.accept(r)
+		if ok && st.take(r, hostValues, st.values, nil, rest) {
+			return
+		}
+	}
+}
+
+// match looks for the first-registered route matching st.path.
+// pos is the offset in st.path just past the segments consumed so far; st.path[pos] is
+// either "/" or pos is at the end of st.path.
+func (n *node) match(st *matchState, pos int) {
+	if st.route != nil && st.route.index < n.minIndex {
+		// no route in this subtree can beat what we have already found
+		return
+	}
+
+	if pos == len(st.path) {
+		st.consider(n.leaves, "")
+	} else {
+		st.consider(n.prefixes, st.path[pos:])
+
+		// extract the next segment
+		start := pos + 1
+		end := strings.IndexByte(st.path[start:], '/')
+		if end == -1 {
+			end = len(st.path)
+		} else {
+			end += start
+		}
+		seg := st.path[start:end]
+
+		if c := n.static[seg]; c != nil {
+			c.match(st, end)
+		}
+		if n.param != nil && len(seg) > 0 {
+			st.values = append(st.values, seg)
+			n.param.match(st, end)
+			st.values = st.values[:len(st.values)-1]
+		}
+		for _, c := range n.patterns {
+			values := c.seg.re.FindStringSubmatch(seg)
+			if len(values) != 1+c.seg.nvars {
+				continue
+			}
+			nvalues := len(st.values)
+			st.values = append(st.values, values[1:]...)
+			c.match(st, end)
+			st.values = st.values[:nvalues]
+		}
+	}
+
+	for _, r := range n.fallback {
+		if !st.better(r) {
+			break
+		}
+		hostValues, ok := st.accept(r)
+		if !ok {
+			continue
+		}
+		loc := r.Pattern.FindStringSubmatchIndex(st.path)
+		if len(loc) != 2+2*r.nvars {
+			continue
+		}
+		var rest string
+		if r.IsPrefix {
+			rest = st.path[loc[1]-1:] // Pattern of a prefix route ends with "/"
+		}
+		values, absent := submatches(st.path, loc)
+		if st.take(r, hostValues, values, absent, rest) {
+			break
+		}
+	}
+}
+
+// unescapeValues decodes values which were matched in an escaped path.
+// values is returned as is when no value needs to be decoded; it is never modified.
+func unescapeValues(values []string) ([]string, bool) {
+	var decoded []string
+	for i, v := range values {
+		if strings.IndexByte(v, '%') == -1 {
+			continue
+		}
+		v, err := url.PathUnescape(v)
+		if err != nil {
+			return nil, false
+		}
+		if decoded == nil {
+			decoded = append([]string(nil), values...)
+		}
+		decoded[i] = v
+	}
+	if decoded == nil {
+		return values, true
+	}
+	return decoded, true
+}
+
+// cleanRest cleans the value of a "{name...}" var, e.g. "a//b/../c/" -> "a/c"
+func cleanRest(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	return path.Clean("/" + s)[1:]
+}
+
+// submatches returns the strings of the submatches at loc (from FindStringSubmatchIndex).
+// absent is non-nil if any submatch did not participate in the match, in which case
+// absent[i] is true for such submatches.
+func submatches(s string, loc []int) (values []string, absent []bool) {
+	values = make([]string, len(loc)/2-1)
+	for i := range values {
+		if start := loc[2+i*2]; start >= 0 {
+			values[i] = s[start:loc[3+i*2]]
+		} else {
+			if absent == nil {
+				absent = make([]bool, len(values))
+			}
+			absent[i] = true
+		}
+	}
+	return values, absent
+}
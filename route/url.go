@@ -0,0 +1,108 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrUnknownRoute is returned by Router.URL when there is no route with the requested name
+var ErrUnknownRoute = errors.New("unknown route")
+
+// URL builds the URL path for the route with the given name.
+// vars are name-value pairs, e.g. URL("user", "id", "123"), and each value must match the
+// pattern of its var. Routes of mounted routers are included, prefixed by their mount point.
+// Host patterns are not part of the result.
+func (r *Router) URL(name string, vars ...string) (string, error) {
+	if len(vars)%2 != 0 {
+		return "", fmt.Errorf("odd number of vars for route %q (expected name-value pairs)", name)
+	}
+	path, err := r.buildPath(name, vars)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Path: path}).EscapedPath(), nil
+}
+
+func (r *Router) buildPath(name string, vars []string) (string, error) {
+	for _, route := range r.Routes {
+		if route.Sub != nil {
+			subpath, err := route.Sub.buildPath(name, vars)
+			if errors.Is(err, ErrUnknownRoute) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			prefix, err := route.buildPath(vars)
+			if err != nil {
+				return "", err
+			}
+			// prefix ends with "/" and subpath starts with "/"
+			return r.BasePath + prefix[:len(prefix)-1] + subpath, nil
+		}
+		if route.Name == name {
+			path, err := route.buildPath(vars)
+			return r.BasePath + path, err
+		}
+	}
+	return "", fmt.Errorf("%w %q", ErrUnknownRoute, name)
+}
+
+// buildPath builds the path of r by substituting its vars with values from vars.
+// Optional groups are included when values are given for all of their vars.
+func (r *Route) buildPath(vars []string) (string, error) {
+	path, missing, err := r.buildParts(r.parts, vars)
+	if err == nil && missing != "" {
+		err = fmt.Errorf("missing value for var %q of route %q", missing, r.Name)
+	}
+	return path, err
+}
+
+// buildParts builds parts of a path. missing is the name of the first var without a value.
+func (r *Route) buildParts(parts []patternPart, vars []string) (path, missing string, err error) {
+	var sb strings.Builder
+	for i := 0; i < len(parts); i++ {
+		p := parts[i]
+		if p.group > 0 {
+			end := groupEnd(parts, i)
+			s, missing1, err := r.buildParts(parts[i+1:end], vars)
+			if err != nil {
+				return "", "", err
+			}
+			if missing1 == "" {
+				sb.WriteString(s)
+			}
+			i = end
+			continue
+		}
+		if !p.isVar {
+			sb.WriteString(p.literal)
+			continue
+		}
+		value, ok := lookupVar(vars, p.name)
+		if !ok {
+			if missing == "" {
+				missing = p.name
+			}
+			continue
+		}
+		if !p.re.MatchString(value) {
+			return "", "", fmt.Errorf("invalid value %q for var %q of route %q (does not match %q)",
+				value, p.name, r.Name, p.pattern)
+		}
+		sb.WriteString(value)
+	}
+	return sb.String(), missing, nil
+}
+
+// lookupVar finds the value of name in name-value pairs
+func lookupVar(vars []string, name string) (string, bool) {
+	for i := 0; i+1 < len(vars); i += 2 {
+		if vars[i] == name {
+			return vars[i+1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,186 @@
+package route
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+)
+
+// findVars finds all vars in a route pattern, e.g. "{id}", "{n:[0-9]{1,3}}" and "{path...}".
+// Each location is [start, end, nameStart, nameEnd, patternStart, patternEnd, rest] where the
+// pattern start and end are -1 when the var has no pattern and rest is 1 for a rest var,
+// i.e. "{name...}", and 0 otherwise.
+// Var patterns may contain braces as long as they are balanced, escaped or in a character class.
+func findVars(s string) ([][]int, error) {
+	var locations [][]int
+	for i := 0; i < len(s); i++ {
+		if s[i] != '{' {
+			continue
+		}
+		nameStart := i + 1
+		nameEnd := nameStart
+		for nameEnd < len(s) && isVarNameByte(s[nameEnd]) {
+			nameEnd++
+		}
+		if nameEnd == nameStart || nameEnd == len(s) {
+			continue // not a var, e.g. "{" in "/a{b"
+		}
+		loc := []int{i, -1, nameStart, nameEnd, -1, -1, 0}
+		switch s[nameEnd] {
+		case '}':
+			loc[1] = nameEnd + 1
+		case '.':
+			if !strings.HasPrefix(s[nameEnd:], restVarSuffix) {
+				continue // not a var
+			}
+			loc[1] = nameEnd + len(restVarSuffix)
+			loc[6] = 1
+		case ':':
+			patStart := nameEnd + 1
+			patEnd := scanVarPattern(s, patStart)
+			if patEnd == -1 {
+				return nil, fmt.Errorf("unterminated var %q", s[i:])
+			}
+			loc[1] = patEnd + 1
+			loc[4] = patStart
+			loc[5] = patEnd
+		default:
+			continue // not a var
+		}
+		locations = append(locations, loc)
+		i = loc[1] - 1
+	}
+	return locations, nil
+}
+
+const restVarSuffix = "...}"
+
+func isVarNameByte(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// scanVarPattern returns the index of the "}" which terminates the var pattern starting at
+// s[start], or -1 if the pattern is not terminated.
+func scanVarPattern(s string, start int) int {
+	depth := 0
+	inClass := false
+	for i := start; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++ // skip escaped character
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+			if i+1 < len(s) && s[i+1] == '^' {
+				i++
+			}
+			if i+1 < len(s) && s[i+1] == ']' {
+				i++ // "]" first in a class is a literal
+			}
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// normalizeVarPattern checks a var's regular expression pattern and rewrites it so that it
+// can be embedded in a route's Pattern: capture groups become non-capturing, so that every
+// var corresponds to exactly one group, and leading "^" and trailing "$" are removed.
+func normalizeVarPattern(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	re, trimmed := trimVarPatternAnchors(re)
+	if err := checkVarPattern(re); err != nil {
+		return "", err
+	}
+	re, stripped := stripCaptures(re)
+	if !trimmed && !stripped {
+		return pattern, nil
+	}
+	return re.String(), nil
+}
+
+// trimVarPatternAnchors removes a leading "^" and trailing "$". E.g. "^\w+$" -> "\w+"
+func trimVarPatternAnchors(re *syntax.Regexp) (*syntax.Regexp, bool) {
+	if re.Op != syntax.OpConcat || len(re.Sub) == 0 {
+		return re, false
+	}
+	sub := re.Sub
+	if op := sub[0].Op; op == syntax.OpBeginText || op == syntax.OpBeginLine {
+		sub = sub[1:]
+	}
+	if len(sub) > 0 {
+		if op := sub[len(sub)-1].Op; op == syntax.OpEndText || op == syntax.OpEndLine {
+			sub = sub[:len(sub)-1]
+		}
+	}
+	if len(sub) == len(re.Sub) {
+		return re, false
+	}
+	re2 := *re
+	re2.Sub = sub
+	if len(sub) == 0 {
+		re2.Op = syntax.OpEmptyMatch
+	}
+	return &re2, true
+}
+
+func checkVarPattern(re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		return fmt.Errorf("anchor %q only allowed at the start or end of a var pattern", re)
+	case syntax.OpNoMatch:
+		return fmt.Errorf("pattern never matches")
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return fmt.Errorf("pattern never matches (empty character class)")
+		}
+	}
+	for _, sub := range re.Sub {
+		if err := checkVarPattern(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripCaptures replaces all capture groups in re with their contents
+func stripCaptures(re *syntax.Regexp) (*syntax.Regexp, bool) {
+	if re.Op == syntax.OpCapture {
+		sub, _ := stripCaptures(re.Sub[0])
+		return sub, true
+	}
+	changed := false
+	for i, sub := range re.Sub {
+		if sub2, ok := stripCaptures(sub); ok {
+			re.Sub[i] = sub2
+			changed = true
+		}
+	}
+	return re, changed
+}
+
+// varPattern resolves and normalizes the pattern of a var in a route pattern.
+// defaultPattern is returned when pattern is empty.
+func varPattern(routePattern, name, pattern, defaultPattern string) (string, *VarType, error) {
+	pattern = strings.TrimSpace(pattern)
+	if len(pattern) == 0 {
+		return defaultPattern, nil, nil
+	}
+	pattern, vt := resolveVarPattern(pattern)
+	pattern, err := normalizeVarPattern(pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid pattern for var %q in route pattern %q: %v",
+			name, routePattern, err)
+	}
+	return pattern, vt, nil
+}
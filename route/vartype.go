@@ -0,0 +1,66 @@
+package route
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VarType is a named var pattern with a converter, e.g. "int" in "/user/{id:int}"
+type VarType struct {
+	Pattern string                            // regular expression
+	Parse   func(string) (interface{}, error) // converts a matched value
+}
+
+// VarTypes are the var types that can be used in route patterns, by name. A var pattern which
+// is not the name of a type is a regular expression. A type is looked up when a pattern is
+// parsed, so a route keeps the type it was added with. Example of a custom type:
+//
+//   route.VarTypes["hex"] = &route.VarType{`[0-9a-f]+`, parseHex} // "/color/{rgb:hex}"
+//
+var VarTypes = map[string]*VarType{
+	"int":  {`-?[0-9]+`, func(s string) (interface{}, error) { return strconv.Atoi(s) }},
+	"uint": {`[0-9]+`, func(s string) (interface{}, error) { return ParseUint(s) }},
+	"slug": {`[a-z0-9]+(?:-[a-z0-9]+)*`, func(s string) (interface{}, error) { return s, nil }},
+	"uuid": {
+		`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+		func(s string) (interface{}, error) { return ParseUUID(s) },
+	},
+	"date": {
+		`[0-9]{4}-[0-9]{2}-[0-9]{2}`,
+		func(s string) (interface{}, error) { return ParseDate(s) },
+	},
+}
+
+// resolveVarPattern returns the type and regexp pattern for a var pattern, which is either
+// the name of a VarType or a regular expression.
+func resolveVarPattern(pattern string) (string, *VarType) {
+	if vt := VarTypes[pattern]; vt != nil {
+		return vt.Pattern, vt
+	}
+	return pattern, nil
+}
+
+// ParseUint parses the value of an "uint" var
+func ParseUint(s string) (uint, error) {
+	v, err := strconv.ParseUint(s, 10, 0)
+	return uint(v), err
+}
+
+// ParseUUID parses the value of an "uuid" var, e.g. "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"
+func ParseUUID(s string) (id [16]byte, err error) {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return id, fmt.Errorf("invalid UUID %q", s)
+	}
+	if _, err = hex.Decode(id[:], []byte(strings.Replace(s, "-", "", 4))); err != nil {
+		return id, fmt.Errorf("invalid UUID %q", s)
+	}
+	return id, nil
+}
+
+// ParseDate parses the value of a "date" var, e.g. "2020-10-30"
+func ParseDate(s string) (time.Time, error) {
+	return time.Parse("2006-01-02", s)
+}
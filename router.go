@@ -1,6 +1,8 @@
 package httpd
 
 import (
+	"strings"
+
 	"github.com/rsms/go-httpd/route"
 )
 
@@ -11,27 +13,88 @@ func (f handlerFunc) ServeHTTP(t *Transaction) { f(t) }
 // Router is a HTTP-specific kind of route.Router
 type Router struct {
 	route.Router
+
+	middleware []Middleware // see Use
+}
+
+// Route is a route of a Router, as returned by Handle. The Handler field of the route.Route
+// of a Route refers to the Route.
+type Route struct {
+	*route.Route
+
+	handler    Handler      // nil for a mount point
+	sub        *Router      // mounted router of a mount point
+	middleware []Middleware // see Use
+}
+
+func newRoute(rt *route.Route, handler Handler, sub *Router) *Route {
+	r := &Route{Route: rt, handler: handler, sub: sub}
+	rt.Handler = r
+	return r
+}
+
+// Unwrap returns the handler of r, or the mounted router if r is a mount point.
+// route.Explain uses it to name the handler of r.
+func (r *Route) Unwrap() interface{} {
+	if r.sub != nil {
+		return r.sub
+	}
+	return r.handler
 }
 
-func (r *Router) HandleFunc(pattern string, f func(*Transaction)) (*route.Route, error) {
+func (r *Router) HandleFunc(pattern string, f func(*Transaction)) (*Route, error) {
 	return r.Handle(pattern, handlerFunc(f))
 }
 
-func (r *Router) Handle(pattern string, handler Handler) (*route.Route, error) {
-	return r.Add(pattern, handler)
+func (r *Router) HandleErrorFunc(pattern string, f func(*Transaction) error) (*Route, error) {
+	return r.Handle(pattern, ErrorHandlerFunc(f))
 }
 
-func (r *Router) Match(t *Transaction) (Handler, error) {
-	// effective conditions of the transaction
-	conditions, _ := route.ParseCondFlags([]string{t.Method()})
+func (r *Router) Handle(pattern string, handler Handler) (*Route, error) {
+	rt, err := r.Add(pattern, handler)
+	if rt == nil {
+		return nil, err
+	}
+	return newRoute(rt, handler, nil), err
+}
+
+// Clone returns a copy of r which can be changed without affecting r. See route.Router.Clone
+func (r *Router) Clone() *Router {
+	r2 := &Router{Router: *r.Router.Clone()}
+	r2.middleware = append(r2.middleware, r.middleware...)
+	for _, rt := range r2.Routes {
+		if r1, ok := rt.Handler.(*Route); ok {
+			rt.Handler = &Route{
+				Route:      rt,
+				handler:    r1.handler,
+				sub:        r1.sub,
+				middleware: append([]Middleware(nil), r1.middleware...),
+			}
+		}
+	}
+	return r2
+}
+
+// Mount dispatches requests matching the prefix pattern to sub. Routes of sub are matched
+// against the part of the path following the prefix, which is also what t.RoutePath() returns.
+// Requests which do not match any route of sub continue to match routes registered after the
+// mount point. See route.Router.Mount
+func (r *Router) Mount(pattern string, sub *Router) (*Route, error) {
+	m, err := r.Router.Mount(pattern, &sub.Router)
+	if m == nil {
+		return nil, err
+	}
+	return newRoute(m, nil, sub), err
+}
 
-	// find a matching route
-	m, err := r.Router.Match(conditions, t.URL.Path)
+func (r *Router) Match(t *Transaction) (Handler, error) {
+	// find a matching route. Conditions are computed from the request, e.g. its method
+	m, err := r.Router.MatchRequest(t.Request)
 	if err != nil || m == nil {
 		return nil, err
 	}
 	t.routeMatch = m
-	return m.Route.Handler.(Handler), nil
+	return r.chain(m), nil
 }
 
 func (r *Router) ServeHTTP(t *Transaction) {
@@ -48,8 +111,38 @@ func (r *Router) MaybeServeHTTP(t *Transaction) bool {
 		return true
 	}
 	if route == nil {
-		return false
+		return r.maybeServeMethodNotAllowed(t)
+	}
+	if t.routeMatch.ImplicitHEAD && t.Method() == "HEAD" {
+		t.headOnly = true
+		route.ServeHTTP(t)
+		t.endHeadOnly()
+		return true
 	}
 	route.ServeHTTP(t)
 	return true
 }
+
+// maybeServeMethodNotAllowed responds to a request which did not match any route but whose
+// path matches routes with other method conditions. OPTIONS requests are answered with
+// "204 No Content" while any other method is answered with "405 Method Not Allowed".
+// In both cases the "Allow" header lists the methods accepted for the path.
+func (r *Router) maybeServeMethodNotAllowed(t *Transaction) bool {
+	allowed, ok := r.Router.Allowed(t.Request.Host, r.URLPath(t.URL))
+	if !ok || allowed == 0 || allowed&route.MethodCond(t.Method()) != 0 {
+		// the method is allowed; the request did not match other conditions of the routes
+		return false
+	}
+	methods := allowed.Methods()
+	if (allowed & route.CondMethodOPTIONS) == 0 {
+		methods = append(methods, "OPTIONS")
+	}
+	t.Header().Set("Allow", strings.Join(methods, ", "))
+	if t.Method() == "OPTIONS" {
+		t.Status = 204
+		t.WriteHeader(t.Status)
+	} else {
+		t.RespondWithStatusMethodNotAllowed()
+	}
+	return true
+}
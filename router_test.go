@@ -0,0 +1,127 @@
+package httpd
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestServerUpdateRoutes(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	old, _ := s.Routes.HandleFunc("/old", func(t *Transaction) { t.WriteString("old") })
+	old.Name = "old"
+	s.Routes.HandleFunc("/b", func(t *Transaction) { t.WriteString("b") })
+
+	// requests in progress are served while the routes are replaced
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if w := serve(s, "GET", "/b", nil); w.Body.String() != "b" {
+				t.Errorf("GET /b: %d %q", w.Code, w.Body.String())
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		err := s.UpdateRoutes(func(r *Router) error {
+			r2, err := r.HandleFunc(fmt.Sprintf("/x/%d", i), func(t *Transaction) {})
+			if r2 != nil {
+				r2.Name = fmt.Sprintf("x%d", i)
+			}
+			return err
+		})
+		assert.NoErr("UpdateRoutes", err)
+	}
+	wg.Wait()
+
+	url, err := s.RouteURL("x19")
+	assert.NoErr("RouteURL of added route", err)
+	assert.Eq("RouteURL of added route", url, "/x/19")
+	url, err = s.RouteURL("old")
+	assert.NoErr("RouteURL", err)
+	assert.Eq("RouteURL", url, "/old")
+
+	assert.Ok("RemoveRoute", s.RemoveRoute(old))
+	assert.Ok("RemoveRoute again", !s.RemoveRoute(old))
+	assert.Eq("removed route", serve(s, "GET", "/old", nil).Code, 404)
+	_, err = s.RouteURL("old")
+	assert.Err("RouteURL of removed route", "unknown route", err)
+
+	// a router built off to the side
+	var r Router
+	r.HandleFunc("/new", func(t *Transaction) {})
+	r.Routes[0].Name = "new"
+	s.SetRoutes(&r)
+	url, err = s.RouteURL("new")
+	assert.NoErr("RouteURL after SetRoutes", err)
+	assert.Eq("RouteURL after SetRoutes", url, "/new")
+	_, err = s.RouteURL("x19")
+	assert.Err("RouteURL of replaced route", "unknown route", err)
+}
+
+func TestServerHandleAfterSetRoutes(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	var r Router
+	r.HandleFunc("/a", func(t *Transaction) { t.WriteString("a") })
+	s.SetRoutes(&r)
+
+	s.HandleFunc("/b", func(t *Transaction) { t.WriteString("b") })
+	s.HandleErrorFunc("/c", func(t *Transaction) error { return nil })
+	assert.Eq("Routes is unused after SetRoutes", len(s.Routes.Routes), 0)
+	assert.Eq("router passed to SetRoutes is unchanged", len(r.Routes), 1)
+	assert.Eq("GET /a", serve(s, "GET", "/a", nil).Body.String(), "a")
+	assert.Eq("GET /b", serve(s, "GET", "/b", nil).Body.String(), "b")
+	assert.Eq("GET /c", serve(s, "GET", "/c", nil).Code, 200)
+
+	err := s.UpdateRoutes(func(r *Router) error { return nil })
+	assert.NoErr("UpdateRoutes", err)
+	s.Handle("/d", handlerFunc(func(t *Transaction) { t.WriteString("d") }))
+	assert.Eq("GET /d", serve(s, "GET", "/d", nil).Body.String(), "d")
+	assert.Eq("routes", len(s.CurrentRoutes().Routes), 4)
+}
+
+func TestRouterImplicitHEAD(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.HandleFunc("GET /a", func(t *Transaction) {
+		t.Header().Set("Content-Type", "text/plain")
+		t.Header().Set("X-A", "1")
+		t.WriteHeader(201)
+		t.WriteString("hello")
+		t.WriteString(" world")
+	})
+	s.HandleFunc("GET /b", func(t *Transaction) {
+		t.Header().Set("Content-Length", "100")
+		t.WriteString("partial")
+	})
+	s.HandleFunc("GET /c", func(t *Transaction) {
+		t.WriteString("GET")
+	})
+	s.HandleFunc("HEAD /c", func(t *Transaction) {
+		t.Header().Set("X-Head", "1")
+	})
+
+	get := serve(s, "GET", "/a", nil)
+	head := serve(s, "HEAD", "/a", nil)
+	assert.Eq("GET body", get.Body.String(), "hello world")
+	assert.Eq("HEAD body", head.Body.String(), "")
+	assert.Eq("HEAD status", head.Code, 201)
+	assert.Eq("HEAD Content-Length", head.Header().Get("Content-Length"), "11")
+	for _, name := range []string{"Content-Type", "X-A"} {
+		assert.Eq("HEAD "+name, head.Header().Get(name), get.Header().Get(name))
+	}
+
+	head = serve(s, "HEAD", "/b", nil)
+	assert.Eq("Content-Length set by handler", head.Header().Get("Content-Length"), "100")
+	assert.Eq("Content-Length set by handler; body", head.Body.String(), "")
+
+	head = serve(s, "HEAD", "/c", nil)
+	assert.Eq("explicit HEAD route", head.Header().Get("X-Head"), "1")
+	assert.Eq("explicit HEAD route; body", head.Body.String(), "")
+}
@@ -2,17 +2,21 @@ package httpd
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/signal"
 	"path"
 	"runtime/debug"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/rsms/go-httpd/route"
 	"github.com/rsms/go-httpd/session"
 	"github.com/rsms/go-log"
 	"github.com/rsms/gotalk"
@@ -21,9 +25,19 @@ import (
 type Server struct {
 	Logger   *log.Logger   // defaults to log.RootLogger
 	PubDir   string        // directory to serve files from. File serving is disabled if empty.
-	Routes   Router        // http request routes
+	Routes   Router        // http request routes (see SetRoutes for changing routes later)
 	Server   http.Server   // underlying http server
 	Sessions session.Store // Call Sessions.SetStorage(s) to enable sessions
+	Encoders []Encoder     // used by Transaction.Respond (DefaultEncoders if nil)
+
+	// ErrorRenderer writes status and error responses (DefaultErrorRenderer if nil)
+	ErrorRenderer ErrorRenderer
+
+	MaxJSONBodySize int64 // limit of Transaction.ReadJSON (DefaultMaxJSONBodySize if zero)
+	StrictJSON      bool  // reject unknown object fields in Transaction.ReadJSON
+
+	UploadLimits UploadLimits // used by Transaction.UploadReader
+	Compression  *Compression // response compression; set to nil to disable
 
 	Gotalk     *gotalk.WebSocketServer // set to nil to disable gotalk
 	GotalkPath string                  // defaults to "/gotalk/"
@@ -34,6 +48,16 @@ type Server struct {
 	gotalkSocks         map[*gotalk.WebSocket]int    // currently connected gotalk sockets
 	gotalkOnConnectUser func(sock *gotalk.WebSocket) // saved value of .Gotalk.OnConnect
 
+	routesMu sync.Mutex   // serializes SetRoutes and UpdateRoutes
+	routes   atomic.Value // *Router set by SetRoutes
+
+	errorTemplates sync.Map // map[string]Template; see errorTemplate
+
+	shutdownMu sync.Mutex    // protects shutdown field
+	shutdown   chan struct{} // closed when Shutdown starts (see shutdownChan)
+
+	connContextUser func(ctx context.Context, c net.Conn) context.Context // saved .Server.ConnContext
+
 	gracefulShutdownTimeout time.Duration
 }
 
@@ -50,6 +74,8 @@ func NewServer(pubDir, addr string) *Server {
 		Gotalk:     gotalk.WebSocketHandler(),
 		GotalkPath: "/gotalk/",
 	}
+	compression := DefaultCompression
+	s.Compression = &compression
 
 	if len(pubDir) > 0 {
 		s.fileHandler = http.FileServer(http.Dir(pubDir))
@@ -58,6 +84,9 @@ func NewServer(pubDir, addr string) *Server {
 	s.Server.Handler = s
 	s.Gotalk.Handlers = gotalk.NewHandlers()
 	s.Server.RegisterOnShutdown(func() {
+		// end event streams
+		s.closeShutdownChan()
+
 		// close all connected sockets
 		s.gotalkSocksMu.RLock()
 		defer s.gotalkSocksMu.RUnlock()
@@ -83,17 +112,40 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "CONNECT" {
 		// strip port and clean path
 		url := *r.URL
-		path := cleanPath(r.URL.Path)
-
-		// redirect if the path was not canonical
-		if path != r.URL.Path {
+		if r.URL.RawPath != "" {
+			// The path contains encoded characters like "%2F" which must not be decoded by
+			// cleaning, so clean the escaped path instead. Encoded dots are decoded first so
+			// that "/a/%2E%2E/b" is cleaned like "/a/../b"; other encoded characters are left
+			// as is.
+			escaped := r.URL.EscapedPath()
+			rawPath := escaped
+			if p := encodedDots.Replace(escaped); cleanPath(p) != p {
+				rawPath = cleanPath(p)
+			}
+			path, err := neturl.PathUnescape(rawPath)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if hasDotSegment(path) {
+				// dot segments formed by encoded slashes, e.g. "/a%2F..%2Fb"
+				path = cleanPath(path)
+				rawPath = ""
+			}
+			if rawPath != escaped {
+				url.Path = path
+				url.RawPath = rawPath
+				http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
+				return
+			}
+		} else if path := cleanPath(r.URL.Path); path != r.URL.Path {
+			// redirect since the path was not canonical
 			url.Path = path
 			http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
 			return
 		}
 
 		// set cleaned valued
-		url.Path = path
 		r.Host = stripHostPort(r.Host)
 	}
 
@@ -106,6 +158,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// create a new transaction
 	t := NewTransaction(s, w, r)
+	defer t.endCompression()
 
 	// recover panic and turn it into an error
 	defer func() {
@@ -114,18 +167,23 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if s.Logger.Level <= log.LevelDebug {
 				s.LogDebug("ServeHTTP error: %s\n%s", err, string(debug.Stack()))
 			}
-			t.RespondWithMessage(500, err)
+			t.headOnly = false // respond directly rather than deferring the header
+			if DevMode {
+				t.RespondWithMessage(500, err)
+			} else {
+				t.RespondWithMessage(500, nil)
+			}
 		}
 	}()
 
 	// serve
-	if s.Routes.MaybeServeHTTP(t) {
+	if s.CurrentRoutes().MaybeServeHTTP(t) {
 		return
 	}
 
 	// fallback to serving files, if configured
 	if s.fileHandler != nil {
-		s.fileHandler.ServeHTTP(w, r)
+		s.fileHandler.ServeHTTP(&fileErrorWriter{ResponseWriter: t, t: t}, r)
 		return
 	}
 
@@ -155,6 +213,19 @@ func cleanPath(p string) string {
 	return np
 }
 
+// encodedDots decodes encoded dots, which are equivalent to unencoded ones (RFC 3986)
+var encodedDots = strings.NewReplacer("%2E", ".", "%2e", ".")
+
+// hasDotSegment returns true if the path p contains a "." or ".." segment
+func hasDotSegment(p string) bool {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
 // stripHostPort returns h without any trailing ":<port>".
 func stripHostPort(h string) string {
 	// If no port on host, return unchanged
@@ -181,8 +252,14 @@ type Handler interface {
 // The server takes care of sanitizing the URL request path and the Host header,
 // stripping the port number and redirecting any request containing . or ..
 // elements or repeated slashes to an equivalent, cleaner URL.
+//
+// Invalid patterns and routes which can never match are logged.
+// Use s.Routes.Handle, or r.Handle with UpdateRoutes, to handle such errors yourself.
+// Once SetRoutes or UpdateRoutes has been called, routes are added to the current router
+// with UpdateRoutes. Until then they are added to s.Routes, which must not happen while the
+// server is serving requests; use UpdateRoutes.
 func (s *Server) Handle(pattern string, handler Handler) {
-	s.Routes.Handle(pattern, handler)
+	s.addRoute(func(r *Router) (*Route, error) { return r.Handle(pattern, handler) })
 }
 
 // HandleFunc registers a HTTP request handler function for the given pattern.
@@ -191,7 +268,103 @@ func (s *Server) Handle(pattern string, handler Handler) {
 // stripping the port number and redirecting any request containing . or ..
 // elements or repeated slashes to an equivalent, cleaner URL.
 func (s *Server) HandleFunc(pattern string, handler func(*Transaction)) {
-	s.Routes.HandleFunc(pattern, handler)
+	s.addRoute(func(r *Router) (*Route, error) { return r.HandleFunc(pattern, handler) })
+}
+
+// HandleErrorFunc registers a HTTP request handler function which returns an error for the
+// given pattern. See ErrorHandlerFunc
+func (s *Server) HandleErrorFunc(pattern string, handler func(*Transaction) error) {
+	s.addRoute(func(r *Router) (*Route, error) {
+		return r.HandleErrorFunc(pattern, handler)
+	})
+}
+
+// addRoute calls add with the router which Handle and friends add routes to and logs errors
+func (s *Server) addRoute(add func(r *Router) (*Route, error)) {
+	if s.routes.Load() == nil {
+		s.logRouteError(add(&s.Routes))
+		return
+	}
+	s.UpdateRoutes(func(r *Router) error {
+		s.logRouteError(add(r))
+		return nil
+	})
+}
+
+// CurrentRoutes returns the router which new requests are dispatched to. This is &s.Routes
+// until SetRoutes or UpdateRoutes is called.
+func (s *Server) CurrentRoutes() *Router {
+	if r, _ := s.routes.Load().(*Router); r != nil {
+		return r
+	}
+	return &s.Routes
+}
+
+// SetRoutes replaces the router which requests are dispatched to. It is safe to call while
+// the server is serving requests; requests in progress finish with the router they started
+// with. r must not be changed after the call; use UpdateRoutes for making further changes.
+func (s *Server) SetRoutes(r *Router) {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	s.routes.Store(r)
+}
+
+// UpdateRoutes calls f with a clone of the current router and, unless f returns an error,
+// replaces the current router with it. It is safe to call while the server is serving requests.
+//
+// Example of replacing a route:
+//
+//   err := s.UpdateRoutes(func(r *Router) error {
+//     r.Remove(oldRoute)
+//     _, err := r.HandleFunc("/foo", handleFoo)
+//     return err
+//   })
+//
+func (s *Server) UpdateRoutes(f func(r *Router) error) error {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	r := s.CurrentRoutes().Clone()
+	if err := f(r); err != nil {
+		return err
+	}
+	s.routes.Store(r)
+	return nil
+}
+
+// RemoveRoute removes a route, identified by what Handle returned when the route was added.
+// It is safe to call while the server is serving requests. Returns false if there is no
+// such route.
+func (s *Server) RemoveRoute(rt *Route) bool {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	r := s.CurrentRoutes().Clone()
+	if !r.Remove(rt.Route) {
+		return false
+	}
+	s.routes.Store(r)
+	return true
+}
+
+// RouteURL builds the URL path of a named route of the current routes of s.
+// vars are name-value pairs and values are formatted with fmt.Sprint. This function is
+// available to templates as "routeurl"; see TemplateHelpers. See also route.Router.URL
+func (s *Server) RouteURL(name string, vars ...interface{}) (string, error) {
+	strvars := make([]string, len(vars))
+	for i, v := range vars {
+		strvars[i] = fmt.Sprint(v)
+	}
+	return s.CurrentRoutes().URL(name, strvars...)
+}
+
+func (s *Server) logRouteError(_ *Route, err error) {
+	if err == nil {
+		return
+	}
+	if _, ok := err.(*route.ConflictError); ok {
+		s.LogWarn("%v", err)
+	} else {
+		s.LogError("%v", err)
+	}
 }
 
 // HandleGotalk registers a Gotalk request handler for the given operation,
@@ -253,6 +426,10 @@ func (s *Server) prepareToServe() {
 	// 	}()
 	// }
 
+	// Make the connection of a request available to it, for EventStream
+	s.connContextUser = s.Server.ConnContext
+	s.Server.ConnContext = s.connContext
+
 	if s.Gotalk != nil {
 		// Install the gotalk connect handler here rather than when creating the Server struct so that
 		// in case the user installed a handler, we can wrap it.
@@ -261,6 +438,37 @@ func (s *Server) prepareToServe() {
 	}
 }
 
+func (s *Server) connContext(ctx context.Context, c net.Conn) context.Context {
+	if s.connContextUser != nil {
+		ctx = s.connContextUser(ctx, c)
+	}
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// connKey is the context key of the net.Conn of a request
+type connKey struct{}
+
+// shutdownChan returns a channel which is closed when Shutdown starts
+func (s *Server) shutdownChan() chan struct{} {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shutdown == nil {
+		s.shutdown = make(chan struct{})
+	}
+	return s.shutdown
+}
+
+func (s *Server) closeShutdownChan() {
+	ch := s.shutdownChan()
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	select {
+	case <-ch: // already closed
+	default:
+		close(ch)
+	}
+}
+
 func (s *Server) justBeforeServing(ln net.Listener, protoname, extraLogMsg string) {
 	s.LogInfo("listening on %s://%s (pubdir %q%s)", protoname, ln.Addr(), s.PubDir, extraLogMsg)
 }
@@ -0,0 +1,40 @@
+package httpd
+
+import (
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestServerCleanPath(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.Routes.EscapedPath = true
+	s.HandleFunc("GET /doc/{id}", func(t *Transaction) { t.WriteString(t.RouteVar("id")) })
+	s.HandleFunc("GET /{path...}", func(t *Transaction) { t.WriteString("path " + t.URL.Path) })
+
+	w := serve(s, "GET", "/doc/a%2Fb", nil)
+	assert.Eq("encoded slash", w.Body.String(), "a/b")
+	w = serve(s, "GET", "/doc/a%2Ex", nil)
+	assert.Eq("encoded dot which is not a dot segment", w.Body.String(), "a.x")
+
+	for target, location := range map[string]string{
+		"/x//y":              "/x/y",
+		"/x/./y":             "/x/y",
+		"/x/../doc/a%2Fb":    "/doc/a%2Fb",
+		"/x//doc/a%2F%2Fb":   "/x/doc/a%2F%2Fb",
+		"/a/%2E%2E/b":        "/b",
+		"/a/%2e%2e/b":        "/b",
+		"/a/%2E/b":           "/a/b",
+		"/a/.%2E/%2E./b":     "/b",
+		"/%2E%2E/%2e%2e/etc": "/etc",
+		"/a/%2E%2E/b%2Fc":    "/b%2Fc",
+		"/a%2F..%2Fb":        "/b",
+		"/a/b%2F%2E%2E":      "/a",
+		"/a/%2E%2E/b?q=1":    "/b?q=1",
+	} {
+		w := serve(s, "GET", target, nil)
+		assert.Eq(target+" status", w.Code, 301)
+		assert.Eq(target+" location", w.Header().Get("Location"), location)
+	}
+}
@@ -1,6 +1,7 @@
 package httpd
 
 import (
+	"errors"
 	"fmt"
 	"path"
 	"strings"
@@ -52,6 +53,11 @@ func buildStandardTemplateHelpers() TemplateHelpersMap {
 		return path.Join(args...)
 	}
 
+	// routeurl needs a server; see Server.TemplateHelpers
+	h["routeurl"] = func(name string, vars ...interface{}) (string, error) {
+		return "", errors.New("routeurl used in a template without Server.TemplateHelpers")
+	}
+
 	h["timestamp"] = func(v ...interface{}) int64 {
 		if len(v) == 0 {
 			return time.Now().UTC().Unix()
@@ -66,6 +72,22 @@ func buildStandardTemplateHelpers() TemplateHelpersMap {
 	return h
 }
 
+// TemplateHelpers returns helper functions which depend on s, for use with Template.Funcs:
+//
+//   routeurl name [var value ...]  -- URL path of a named route (see Server.RouteURL)
+//
+// These are used by error page templates. Other templates need them added, for example:
+//
+//   tpl, err := httpd.ParseHtmlTemplateFile("page.html")
+//   ...
+//   tpl.Funcs(s.TemplateHelpers())
+//
+func (s *Server) TemplateHelpers() TemplateHelpersMap {
+	return TemplateHelpersMap{
+		"routeurl": s.RouteURL,
+	}
+}
+
 // ----------------
 
 // func cleanFileName(basedir, name string) string {
@@ -0,0 +1,47 @@
+package httpd
+
+import (
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestTemplateHelpersRouteURL(t *testing.T) {
+	assert := testutil.NewAssert(t)
+
+	// the same route name in two servers
+	s1 := NewServer("", "")
+	r, _ := s1.Routes.HandleFunc("/a/{id}", func(t *Transaction) {})
+	r.Name = "item"
+	s2 := NewServer("", "")
+	r, _ = s2.Routes.HandleFunc("/b/{id}/item", func(t *Transaction) {})
+	r.Name = "item"
+
+	const text = `<a href="{{routeurl "item" "id" 3}}">`
+	for _, test := range []struct {
+		s      *Server
+		expect string
+	}{
+		{s1, `<a href="/a/3">`},
+		{s2, `<a href="/b/3/item">`},
+	} {
+		tpl, err := ParseHtmlTemplate("t", text)
+		assert.NoErr("ParseHtmlTemplate", err)
+		tpl.Funcs(test.s.TemplateHelpers())
+		b, err := tpl.ExecBuf(nil)
+		assert.NoErr("ExecBuf", err)
+		assert.Eq("routeurl", string(b), test.expect)
+	}
+
+	tpl, err := ParseHtmlTemplate("t", `{{routeurl "nope"}}`)
+	assert.NoErr("ParseHtmlTemplate", err)
+	tpl.Funcs(s1.TemplateHelpers())
+	_, err = tpl.ExecBuf(nil)
+	assert.Err("routeurl of unknown route", "unknown route", err)
+
+	// without Server.TemplateHelpers
+	tpl, err = ParseHtmlTemplate("t", text)
+	assert.NoErr("ParseHtmlTemplate", err)
+	_, err = tpl.ExecBuf(nil)
+	assert.Err("routeurl without server", "Server.TemplateHelpers", err)
+}
@@ -3,7 +3,6 @@ package httpd
 import (
 	"context"
 	"fmt"
-	"html"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -30,9 +29,15 @@ type Transaction struct {
 	AuxData map[string]interface{} // can be used to associate arbitrary data with a transaction
 
 	headerWritten bool
+	headOnly      bool       // HEAD request served by a GET route; body is discarded
+	headBodySize  int64      // number of body bytes discarded when headOnly is true
 	query         url.Values // initially nil (it's a map); cached value of .URL.Query()
 	session       *session.Session
-	routeMatch    *route.Match // non-nil when the transaction went through HttpRouter
+	routeMatch    *route.Match        // non-nil when the transaction went through HttpRouter
+	compress      *compressWriter     // non-nil while the response is being compressed
+	noCompress    bool                // see DisableCompression
+	writers       []*middlewareWriter // see HTTPMiddleware
+	writerDepth   int                 // number of writers being written to; see nextWriter
 }
 
 // thread-safe pool of free Transaction objects reduces memory thrash
@@ -56,7 +61,13 @@ func gcTransaction(t *Transaction) {
 	t.Request = nil
 	t.URL = nil
 	t.headerWritten = false
+	t.headOnly = false
+	t.headBodySize = 0
 	t.query = nil
+	t.compress = nil
+	t.noCompress = false
+	t.writers = t.writers[:0]
+	t.writerDepth = 0
 	// t.user = nil
 	// t.userLoaded = false
 	t.session = nil
@@ -98,12 +109,67 @@ func (t *Transaction) Var(name string) string {
 	return t.Request.FormValue(name)
 }
 
-// parameter from URL route
-func (t *Transaction) RouteVar(name string) string {
+// parameter from URL route.
+// The value of a rest var, e.g. "path" in "/files/{path...}", is a cleaned path without
+// leading or trailing "/", e.g. "a/b" for "/files/a//b/".
+// fallback[0] is returned for a var of an optional group which did not match, e.g. "n" in
+// "/blog{/page/{n}}?" for the path "/blog". See route.Match.Var
+func (t *Transaction) RouteVar(name string, fallback ...string) string {
 	if t.routeMatch == nil {
+		if len(fallback) > 0 {
+			return fallback[0]
+		}
 		return ""
 	}
-	return t.routeMatch.Var(name)
+	return t.routeMatch.Var(name, fallback...)
+}
+
+// RouteVarInt returns the value of a route parameter as an int, e.g. "id" in "/user/{id:int}".
+// If the value is not an integer, a "400 Bad Request" response is sent and ok is false.
+func (t *Transaction) RouteVarInt(name string) (v int, ok bool) {
+	v, err := strconv.Atoi(t.RouteVar(name))
+	return v, t.routeVarOk(name, err)
+}
+
+// RouteVarUint returns the value of a route parameter as an uint, e.g. "n" in "/{n:uint}".
+// If the value is not an unsigned integer, a "400 Bad Request" response is sent and ok is false.
+func (t *Transaction) RouteVarUint(name string) (v uint, ok bool) {
+	v, err := route.ParseUint(t.RouteVar(name))
+	return v, t.routeVarOk(name, err)
+}
+
+// RouteVarUUID returns the value of a route parameter as a UUID, e.g. "id" in "/{id:uuid}".
+// If the value is not a UUID, a "400 Bad Request" response is sent and ok is false.
+func (t *Transaction) RouteVarUUID(name string) (v [16]byte, ok bool) {
+	v, err := route.ParseUUID(t.RouteVar(name))
+	return v, t.routeVarOk(name, err)
+}
+
+// RouteVarDate returns the value of a route parameter as a date, e.g. "d" in "/{d:date}".
+// If the value is not a date, a "400 Bad Request" response is sent and ok is false.
+func (t *Transaction) RouteVarDate(name string) (v time.Time, ok bool) {
+	v, err := route.ParseDate(t.RouteVar(name))
+	return v, t.routeVarOk(name, err)
+}
+
+// RouteVarValue returns the value of a route parameter converted according to its type.
+// See route.VarTypes. The value of an untyped parameter is a string.
+// If conversion fails, a "400 Bad Request" response is sent and ok is false.
+func (t *Transaction) RouteVarValue(name string) (v interface{}, ok bool) {
+	if t.routeMatch == nil {
+		return "", true
+	}
+	v, err := t.routeMatch.Value(name)
+	return v, t.routeVarOk(name, err)
+}
+
+func (t *Transaction) routeVarOk(name string, err error) bool {
+	if err != nil {
+		t.Server.LogDebug("invalid value for route var %q: %v", name, err)
+		t.RespondWithStatusBadRequest()
+		return false
+	}
+	return true
 }
 
 // FormVar retrieves a POST, PATCH or PUT form parameter
@@ -129,7 +195,9 @@ func (t *Transaction) Query() url.Values {
 	return t.query
 }
 
-// Form returns all POST, PATCH or PUT parameters
+// Form returns all POST, PATCH or PUT parameters.
+// A multipart body is read in its entirety first, with files larger than 32MB stored in
+// temporary files. Use UploadReader to read large uploads as they arrive.
 func (t *Transaction) Form() url.Values {
 	// cause ParseMultipartForm to be called with
 	const maxMemory = 32 << 20 // 32 MB (matches defaultMaxMemory of go/net/http/request.go)
@@ -183,7 +251,30 @@ func (t *Transaction) SetCookie(cookie string) error {
 	return util.HeaderSetCookie(t.Header(), cookie)
 }
 
+// Header returns the header map of the response. See http.ResponseWriter
+func (t *Transaction) Header() http.Header {
+	if w := t.nextWriter(); w != nil {
+		defer t.leaveWriter()
+		return w.Header()
+	}
+	return t.ResponseWriter.Header()
+}
+
 func (t *Transaction) WriteHeader(statusCode int) {
+	if w := t.nextWriter(); w != nil {
+		defer t.leaveWriter()
+		w.writeHeader(statusCode)
+		return
+	}
+	if t.headOnly {
+		// header is written by endHeadOnly, after the handler has returned
+		t.Status = statusCode
+		return
+	}
+	t.writeHeader(statusCode)
+}
+
+func (t *Transaction) writeHeader(statusCode int) {
 	if !t.headerWritten {
 		t.headerWritten = true
 		if t.session != nil {
@@ -191,12 +282,27 @@ func (t *Transaction) WriteHeader(statusCode int) {
 				t.Server.LogError("Transaction.WriteHeader;Session.SaveHTTP error: %v", err)
 			}
 		}
+		if t.startCompression(statusCode) {
+			return // header is written by t.compress
+		}
 		t.ResponseWriter.WriteHeader(statusCode)
 	}
 }
 
 func (t *Transaction) Write(data []byte) (int, error) {
+	if w := t.nextWriter(); w != nil {
+		defer t.leaveWriter()
+		w.writeHeader(t.Status)
+		return w.Write(data)
+	}
+	if t.headOnly {
+		t.headBodySize += int64(len(data))
+		return len(data), nil
+	}
 	t.WriteHeader(t.Status)
+	if t.compress != nil {
+		return t.compress.write(t, data)
+	}
 	return t.ResponseWriter.Write(data)
 }
 
@@ -213,7 +319,19 @@ func (t *Transaction) Printf(format string, arg ...interface{}) (int, error) {
 }
 
 func (t *Transaction) Flush() bool {
-	t.WriteHeader(t.Status)
+	if w := t.nextWriter(); w != nil {
+		defer t.leaveWriter()
+		w.writeHeader(t.Status)
+		flusher, ok := w.ResponseWriter.(http.Flusher)
+		if ok {
+			flusher.Flush()
+		}
+		return ok
+	}
+	t.writeHeader(t.Status)
+	if t.compress != nil {
+		t.compress.flush(t)
+	}
 	flusher, ok := t.ResponseWriter.(http.Flusher)
 	if ok {
 		flusher.Flush()
@@ -221,6 +339,20 @@ func (t *Transaction) Flush() bool {
 	return ok
 }
 
+// endHeadOnly completes a response to a HEAD request which was served by a GET route.
+// Unless the handler set Content-Length, it is set to the size of the discarded body.
+func (t *Transaction) endHeadOnly() {
+	t.headOnly = false
+	if t.headerWritten {
+		return
+	}
+	h := t.Header()
+	if t.headBodySize > 0 && h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" {
+		h.Set("Content-Length", strconv.FormatInt(t.headBodySize, 10))
+	}
+	t.writeHeader(t.Status)
+}
+
 func (t *Transaction) WriteTemplate(tpl Template, data interface{}) error {
 	buf, err := tpl.ExecBuf(data)
 	if err != nil {
@@ -273,23 +405,12 @@ func (t *Transaction) AbsFilePath(filename string) string {
 
 func (t *Transaction) ServeFile(filename string) {
 	filename = t.AbsFilePath(filename)
-	http.ServeFile(t, t.Request, filename)
-}
-
-func (t *Transaction) RespondWithMessage(statusCode int, msg interface{}) {
-	body := "<body><h1>" + http.StatusText(statusCode) + "</h1>"
-	if msg != nil {
-		body += "<code>" + html.EscapeString(fmt.Sprint(msg)) + "</code>"
-	}
-	body += "</body>"
-	t.Header().Set("Content-Type", "text/html; charset=utf-8")
-	t.Header().Set("Content-Length", strconv.Itoa(len(body)))
-	t.Status = statusCode
-	t.Write([]byte(body))
+	http.ServeFile(&fileErrorWriter{ResponseWriter: t, t: t}, t.Request, filename)
 }
 
+// RespondWithStatus responds with statusCode and no message. See RespondWithMessage
 func (t *Transaction) RespondWithStatus(statusCode int) {
-	t.RespondWithMessage(statusCode, t.URL.String())
+	t.RespondWithMessage(statusCode, nil)
 }
 
 func (t *Transaction) rws(statusCode int) { t.RespondWithStatus(statusCode) }
@@ -0,0 +1,65 @@
+package httpd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+func TestTransactionRouteVarTypes(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.HandleFunc("/int/{v}", func(t *Transaction) {
+		if v, ok := t.RouteVarInt("v"); ok {
+			fmt.Fprintf(t, "%T %v", v, v)
+		}
+	})
+	s.HandleFunc("/uint/{v}", func(t *Transaction) {
+		if v, ok := t.RouteVarUint("v"); ok {
+			fmt.Fprintf(t, "%T %v", v, v)
+		}
+	})
+	s.HandleFunc("/uuid/{v}", func(t *Transaction) {
+		if v, ok := t.RouteVarUUID("v"); ok {
+			fmt.Fprintf(t, "%x", v)
+		}
+	})
+	s.HandleFunc("/date/{v}", func(t *Transaction) {
+		if v, ok := t.RouteVarDate("v"); ok {
+			t.WriteString(v.Format("Jan 2 2006"))
+		}
+	})
+	s.HandleFunc("/value/{v:int}/{s}", func(t *Transaction) {
+		v, ok1 := t.RouteVarValue("v")
+		s, ok2 := t.RouteVarValue("s")
+		if ok1 && ok2 {
+			fmt.Fprintf(t, "%T %v %T %v", v, v, s, s)
+		}
+	})
+	for _, test := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/int/-42", 200, "int -42"},
+		{"/int/4x", 400, ""},
+		{"/int/99999999999999999999", 400, ""},
+		{"/uint/42", 200, "uint 42"},
+		{"/uint/-1", 400, ""},
+		{"/uint/99999999999999999999", 400, ""},
+		{"/uuid/F81D4FAE-7DEC-11D0-A765-00A0C91E6BF6", 200, "f81d4fae7dec11d0a76500a0c91e6bf6"},
+		{"/uuid/f81d4fae-7dec-11d0-a765-00a0c91e6bfz", 400, ""},
+		{"/uuid/f81d4fae7dec11d0a76500a0c91e6bf6", 400, ""},
+		{"/date/2020-10-30", 200, "Oct 30 2020"},
+		{"/date/2020-13-01", 400, ""},
+		{"/value/7/x", 200, "int 7 string x"},
+		{"/value/99999999999999999999/x", 400, ""},
+	} {
+		w := serve(s, "GET", test.path, nil)
+		assert.Eq(test.path+" status", w.Code, test.status)
+		if test.status == 200 {
+			assert.Eq(test.path+" body", w.Body.String(), test.body)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package httpd
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+)
+
+// UploadLimits limits the size of multipart uploads. See Transaction.UploadReader.
+// A zero field means that the limit of Server.UploadLimits, or else DefaultUploadLimits, is used
+// while a negative field means "no limit".
+type UploadLimits struct {
+	MaxSize      int64 // size of the request body
+	MaxParts     int   // number of parts
+	MaxFileSize  int64 // size of each file part
+	MaxValueSize int64 // size of each non-file part (form value)
+}
+
+// DefaultUploadLimits are the limits used for fields which are zero in both the limits passed
+// to Transaction.UploadReader and Server.UploadLimits
+var DefaultUploadLimits = UploadLimits{
+	MaxSize:      32 << 20, // 32MB
+	MaxParts:     100,
+	MaxFileSize:  32 << 20, // 32MB
+	MaxValueSize: 1 << 20,  // 1MB
+}
+
+// UploadReader reads the parts of a multipart request body one at a time, as they arrive,
+// without buffering the whole body. See Transaction.UploadReader
+type UploadReader struct {
+	t      *Transaction
+	r      *multipart.Reader
+	body   *limitedReader
+	limits UploadLimits
+	nparts int
+	err    error // sticky *HTTPError
+}
+
+// UploadPart is a part of a multipart request body. FormName returns the name of the form
+// field and FileName returns the name of an uploaded file, or "" for a form value.
+type UploadPart struct {
+	*multipart.Part
+	u     *UploadReader
+	limit int64 // remaining bytes; negative for no limit
+}
+
+// UploadReader returns a reader of the parts of a "multipart/form-data" request body, like
+// a form with file inputs. If limits is nil, t.Server.UploadLimits is used.
+//
+// When a limit is exceeded, the response "413 Request Entity Too Large" is sent and a
+// *HTTPError is returned, as it is for other problems with the request ("415 Unsupported
+// Media Type" when the request is not multipart and "400 Bad Request" for malformed bodies).
+// Such an error can be returned from an ErrorHandlerFunc. Example:
+//
+//   u, err := t.UploadReader(&httpd.UploadLimits{MaxFileSize: 100 << 20})
+//   if err != nil {
+//     return err
+//   }
+//   for {
+//     part, err := u.NextPart()
+//     if err == io.EOF {
+//       break
+//     } else if err != nil {
+//       return err
+//     }
+//     if part.FileName() != "" {
+//       filename, _, err := part.SaveToDir(uploadDir)
+//       ...
+//     } else {
+//       value, err := part.Value()
+//       ...
+//     }
+//   }
+//
+func (t *Transaction) UploadReader(limits *UploadLimits) (*UploadReader, error) {
+	u := &UploadReader{t: t}
+	if limits != nil {
+		u.limits = *limits
+	}
+	if t.Server != nil {
+		u.limits.merge(&t.Server.UploadLimits)
+	}
+	u.limits.merge(&DefaultUploadLimits)
+
+	ctype, params, err := mime.ParseMediaType(t.Request.Header.Get("Content-Type"))
+	if err != nil || ctype != "multipart/form-data" || params["boundary"] == "" {
+		return nil, u.fail(415, "expected multipart/form-data content type")
+	}
+	if u.limits.MaxSize >= 0 && t.Request.ContentLength > u.limits.MaxSize {
+		return nil, u.fail(413, errBodyTooLarge.Error())
+	}
+	if t.Request.Body == nil {
+		return nil, u.fail(400, "empty request body")
+	}
+	var body io.Reader = t.Request.Body
+	if u.limits.MaxSize >= 0 {
+		u.body = &limitedReader{r: body, n: u.limits.MaxSize}
+		body = u.body
+	}
+	u.r = multipart.NewReader(body, params["boundary"])
+	return u, nil
+}
+
+// merge sets limits of l which are zero to those of l2
+func (l *UploadLimits) merge(l2 *UploadLimits) {
+	if l.MaxSize == 0 {
+		l.MaxSize = l2.MaxSize
+	}
+	if l.MaxParts == 0 {
+		l.MaxParts = l2.MaxParts
+	}
+	if l.MaxFileSize == 0 {
+		l.MaxFileSize = l2.MaxFileSize
+	}
+	if l.MaxValueSize == 0 {
+		l.MaxValueSize = l2.MaxValueSize
+	}
+}
+
+// NextPart returns the next part of the body, or io.EOF when there are no more parts.
+// Any unread data of the previous part is skipped.
+func (u *UploadReader) NextPart() (*UploadPart, error) {
+	if u.err != nil {
+		return nil, u.err
+	}
+	p, err := u.r.NextPart()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, u.readError(err)
+	}
+	u.nparts++
+	if u.limits.MaxParts >= 0 && u.nparts > u.limits.MaxParts {
+		return nil, u.fail(413, "too many parts in request body")
+	}
+	part := &UploadPart{Part: p, u: u, limit: u.limits.MaxValueSize}
+	if p.FileName() != "" {
+		part.limit = u.limits.MaxFileSize
+	}
+	return part, nil
+}
+
+// fail responds with status and returns a *HTTPError which is also returned by any
+// subsequent calls to u
+func (u *UploadReader) fail(status int, message string) error {
+	if u.err == nil {
+		u.err = NewHTTPError(status, message, nil)
+		u.t.RespondWithMessage(status, message)
+	}
+	return u.err
+}
+
+// readError handles an error from reading the body
+func (u *UploadReader) readError(err error) error {
+	if u.body != nil && u.body.n < 0 {
+		return u.fail(413, errBodyTooLarge.Error())
+	}
+	if u.err == nil {
+		herr := NewHTTPError(400, "malformed multipart request body", err)
+		u.err = herr
+		u.t.RespondWithMessage(herr.Status, herr.Message)
+	}
+	return u.err
+}
+
+// Read reads the data of the part. A *HTTPError is returned if the part or the body is too
+// large or if the body is malformed.
+func (p *UploadPart) Read(b []byte) (int, error) {
+	if p.u.err != nil {
+		return 0, p.u.err
+	}
+	if p.limit >= 0 && int64(len(b)) > p.limit+1 {
+		b = b[:p.limit+1] // read one byte past the limit to detect an oversized part
+	}
+	n, err := p.Part.Read(b)
+	if p.limit >= 0 {
+		if p.limit -= int64(n); p.limit < 0 {
+			if p.FileName() != "" {
+				return 0, p.u.fail(413, "file "+p.FileName()+" is too large")
+			}
+			return 0, p.u.fail(413, "value of "+p.FormName()+" is too large")
+		}
+	}
+	if err != nil && err != io.EOF {
+		return n, p.u.readError(err)
+	}
+	return n, err
+}
+
+// Value reads the data of the part as a string
+func (p *UploadPart) Value() (string, error) {
+	b, err := ioutil.ReadAll(p)
+	return string(b), err
+}
+
+// SaveTo writes the data of the part to w and returns the number of bytes written
+func (p *UploadPart) SaveTo(w io.Writer) (int64, error) {
+	return io.Copy(w, p)
+}
+
+// SaveToDir writes the data of the part to a new file in dir and returns its filename.
+// The file gets a unique name, with the extension of the part's FileName if it's simple
+// (e.g. ".jpg"). If dir is empty, the default directory for temporary files is used (see
+// os.TempDir). If the data can't be read completely, the file is removed.
+func (p *UploadPart) SaveToDir(dir string) (filename string, size int64, err error) {
+	f, err := ioutil.TempFile(dir, "upload-*"+safeExt(p.FileName()))
+	if err != nil {
+		return "", 0, err
+	}
+	size, err = io.Copy(f, p)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), size, nil
+}
+
+// safeExt returns the extension of filename if it's short and alphanumeric, otherwise ""
+func safeExt(filename string) string {
+	ext := filepath.Ext(filename)
+	if len(ext) < 2 || len(ext) > 10 {
+		return ""
+	}
+	for i := 1; i < len(ext); i++ {
+		c := ext[i]
+		if !(('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')) {
+			return ""
+		}
+	}
+	return ext
+}
@@ -0,0 +1,159 @@
+package httpd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rsms/go-testutil"
+)
+
+// multipartBody returns a multipart/form-data body with a part for each pair of names and
+// values. A name starting with "@" is a file part.
+func multipartBody(pairs ...string) (io.Reader, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i := 0; i < len(pairs); i += 2 {
+		name, value := pairs[i], pairs[i+1]
+		if name[0] == '@' {
+			w, _ := mw.CreateFormFile(name[1:], name[1:]+".txt")
+			io.WriteString(w, value)
+		} else {
+			mw.WriteField(name, value)
+		}
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestTransactionUploadReader(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	dir := t.TempDir()
+	s := NewServer("", "")
+	s.UploadLimits.MaxFileSize = 10
+	var parts []string
+	s.HandleErrorFunc("POST /up", func(t *Transaction) error {
+		parts = nil
+		u, err := t.UploadReader(nil)
+		if err != nil {
+			return err
+		}
+		for {
+			p, err := u.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if p.FileName() != "" {
+				filename, size, err := p.SaveToDir(dir)
+				if err != nil {
+					return err
+				}
+				b, _ := ioutil.ReadFile(filename)
+				parts = append(parts, fmt.Sprintf("%s=%q (%d bytes, %s)",
+					p.FormName(), b, size, safeExt(filename)))
+			} else {
+				v, err := p.Value()
+				if err != nil {
+					return err
+				}
+				parts = append(parts, fmt.Sprintf("%s=%q", p.FormName(), v))
+			}
+		}
+		t.WriteString("ok")
+		return nil
+	})
+	upload := func(pairs ...string) *httptest.ResponseRecorder {
+		body, ctype := multipartBody(pairs...)
+		return serve(s, "POST", "/up", body, "Content-Type", ctype)
+	}
+
+	w := upload("a", "1", "@f", "hello")
+	assert.Eq("status", w.Code, 200)
+	assert.Eq("parts", strings.Join(parts, "; "), `a="1"; f="hello" (5 bytes, .txt)`)
+
+	w = upload("@f", "hello world!")
+	assert.Eq("MaxFileSize status", w.Code, 413)
+	assert.Ok("MaxFileSize body: "+w.Body.String(),
+		strings.Contains(w.Body.String(), "file f.txt is too large"))
+	entries, _ := ioutil.ReadDir(dir)
+	assert.Eq("partial file removed", len(entries), 1)
+
+	s.UploadLimits.MaxValueSize = 3
+	w = upload("a", "1234")
+	assert.Eq("MaxValueSize status", w.Code, 413)
+	assert.Ok("MaxValueSize body: "+w.Body.String(),
+		strings.Contains(w.Body.String(), "value of a is too large"))
+
+	s.UploadLimits.MaxParts = 2
+	w = upload("a", "1", "b", "2", "c", "3")
+	assert.Eq("MaxParts status", w.Code, 413)
+	assert.Eq("parts read before MaxParts", len(parts), 2)
+
+	// MaxSize is checked against Content-Length up front and while reading otherwise
+	s.UploadLimits = UploadLimits{MaxSize: 50}
+	w = upload("a", strings.Repeat("x", 100))
+	assert.Eq("MaxSize status", w.Code, 413)
+	assert.Eq("MaxSize with Content-Length reads nothing", len(parts), 0)
+	body, ctype := multipartBody("a", strings.Repeat("x", 100))
+	r := httptest.NewRequest("POST", "/up", body)
+	r.Header.Set("Content-Type", ctype)
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	assert.Eq("MaxSize without Content-Length status", w.Code, 413)
+
+	// a negative limit means no limit
+	s.UploadLimits = UploadLimits{MaxSize: -1, MaxValueSize: -1}
+	w = upload("a", strings.Repeat("x", 2<<20))
+	assert.Eq("no limit status", w.Code, 200)
+
+	w = serve(s, "POST", "/up", strings.NewReader("a=1"),
+		"Content-Type", "application/x-www-form-urlencoded")
+	assert.Eq("not multipart", w.Code, 415)
+	w = serve(s, "POST", "/up", strings.NewReader("garbage"),
+		"Content-Type", "multipart/form-data; boundary=zz")
+	assert.Eq("malformed body", w.Code, 400)
+}
+
+func TestTransactionUploadReaderLimits(t *testing.T) {
+	assert := testutil.NewAssert(t)
+	s := NewServer("", "")
+	s.UploadLimits.MaxParts = 5
+	var err error
+	var saved bytes.Buffer
+	s.HandleFunc("POST /up", func(t *Transaction) {
+		saved.Reset()
+		var u *UploadReader
+		if u, err = t.UploadReader(&UploadLimits{MaxFileSize: 4}); err != nil {
+			return
+		}
+		assert.Eq("limits", fmt.Sprint(u.limits), fmt.Sprint(UploadLimits{
+			MaxSize:      DefaultUploadLimits.MaxSize,
+			MaxParts:     5,
+			MaxFileSize:  4,
+			MaxValueSize: DefaultUploadLimits.MaxValueSize,
+		}))
+		var p *UploadPart
+		for err == nil {
+			if p, err = u.NextPart(); err == nil {
+				_, err = p.SaveTo(&saved)
+			}
+		}
+	})
+	body, ctype := multipartBody("@f", "1234", "@g", "12345", "a", "1")
+	w := serve(s, "POST", "/up", body, "Content-Type", ctype)
+	assert.Eq("status", w.Code, 413)
+	var herr *HTTPError
+	if assert.Ok("HTTPError", errors.As(err, &herr)) {
+		assert.Eq("HTTPError status", herr.Status, 413)
+	}
+	assert.Eq("data of oversized part is not saved", saved.String(), "1234")
+}